@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// applyDefaultCharset gives seed configurations that do not name a character
+// set or collation of their own the default ones.
+func applyDefaultCharset(seedConfigs []SeedConfig, charset, collation string) {
+	for i := range seedConfigs {
+		if seedConfigs[i].Charset == "" && seedConfigs[i].Collation == "" {
+			seedConfigs[i].Charset = charset
+			seedConfigs[i].Collation = collation
+		}
+	}
+}
+
+// charsetMismatch describes how the character set and collation of an existing
+// database differ from those of its seed configuration, if they do.  Only what
+// the seed configuration names is compared; names are compared with the given
+// function.
+func charsetMismatch(seedConfig SeedConfig, charset, collation string, same func(a, b string) bool) string {
+	if (seedConfig.Charset == "" || same(seedConfig.Charset, charset)) &&
+		(seedConfig.Collation == "" || same(seedConfig.Collation, collation)) {
+		return ""
+	}
+	var wanted []string
+	if seedConfig.Charset != "" {
+		wanted = append(wanted, "character set "+seedConfig.Charset)
+	}
+	if seedConfig.Collation != "" {
+		wanted = append(wanted, "collation "+seedConfig.Collation)
+	}
+	return fmt.Sprintf("database %s has character set %s and collation %s, rather than %s",
+		seedConfig.Name, charset, collation, strings.Join(wanted, " and "))
+}
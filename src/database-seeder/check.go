@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// exitDrift is the exit code of the check subcommand when the server differs
+// from what seeding would produce.
+const exitDrift = 2
+
+// Kinds of drift reported by the check subcommand
+const (
+	driftMissingDatabase  = "missing-database"
+	driftMissingUser      = "missing-user"
+	driftMissingPrivilege = "missing-privilege"
+	driftExtraPrivilege   = "extra-privilege"
+	driftWildcardHost     = "wildcard-host"
+	driftOwner            = "owner"
+	driftRoleAttribute    = "role-attribute"
+	driftCharset          = "charset"
+	driftLimit            = "resource-limit"
+)
+
+// driftFinding is a single difference between the server and what seeding
+// would produce.
+type driftFinding struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+// userDrift lists the differences found for one SeedConfig.
+type userDrift struct {
+	Database string         `json:"database"`
+	Username string         `json:"username"`
+	Findings []driftFinding `json:"findings"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// dbChecker compares what exists on the server for a seed configuration with
+// what seeding it would produce, without changing anything.
+type dbChecker func(context.Context, *connection, SeedConfig) ([]driftFinding, error)
+
+// checkDrift checks each seed configuration for drift.  It returns whether
+// any drift was found, and whether any checks failed.
+func checkDrift(ctx context.Context, db *connection, seedConfigs []SeedConfig) ([]userDrift, bool, bool) {
+	var drifts []userDrift
+	drifted, hasError := false, false
+	for _, seedConfig := range seedConfigs {
+		drift := userDrift{
+			Database: seedConfig.Name,
+			Username: seedConfig.Username,
+			Findings: []driftFinding{},
+		}
+		findings, err := db.dialect.check(ctx, db, seedConfig)
+		if err != nil {
+			drift.Error = err.Error()
+			hasError = true
+		} else if len(findings) > 0 {
+			drift.Findings = findings
+			drifted = true
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts, drifted, hasError
+}
+
+// writeDrift renders the results of a check in the given format ("text" or
+// "json").
+func writeDrift(w io.Writer, drifts []userDrift, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(drifts)
+	case "text":
+		for _, drift := range drifts {
+			fmt.Fprintf(w, "Database %s (user %s):", drift.Database, drift.Username)
+			if drift.Error != "" {
+				fmt.Fprintf(w, " error: %s\n", drift.Error)
+				continue
+			}
+			if len(drift.Findings) == 0 {
+				fmt.Fprintf(w, " no drift\n")
+				continue
+			}
+			fmt.Fprintf(w, "\n")
+			for _, finding := range drift.Findings {
+				fmt.Fprintf(w, "  %s: %s\n", finding.Kind, finding.Detail)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// seedConfigSources lists where seed configurations are read from.  All of
+// the sources given are combined; nothing is cached between runs, so changes
+// to the files (such as a rotated Kubernetes secret) take effect on the next
+// run.
+type seedConfigSources struct {
+	// inline is a JSON string, from -seed-configs or SEEDER_CONFIGS
+	inline string
+	// file is a JSON or YAML file
+	file string
+	// dir holds one subdirectory per database
+	dir string
+}
+
+// loadSeedConfigs reads the seed configurations from each source.
+func loadSeedConfigs(sources seedConfigSources) ([]SeedConfig, error) {
+	var seedConfigs []SeedConfig
+
+	// Without any other source, an empty inline configuration is an error, as
+	// it always has been
+	if sources.inline != "" || (sources.file == "" && sources.dir == "") {
+		err := json.Unmarshal([]byte(sources.inline), &seedConfigs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sources.file != "" {
+		fileConfigs, err := readSeedConfigFile(sources.file)
+		if err != nil {
+			return nil, err
+		}
+		seedConfigs = append(seedConfigs, fileConfigs...)
+	}
+
+	if sources.dir != "" {
+		dirConfigs, err := readSeedConfigDir(sources.dir)
+		if err != nil {
+			return nil, err
+		}
+		seedConfigs = append(seedConfigs, dirConfigs...)
+	}
+
+	return seedConfigs, nil
+}
+
+// readSeedConfigFile reads a list of seed configurations from a file, as YAML
+// if its name ends in .yml or .yaml, and as JSON otherwise.
+func readSeedConfigFile(path string) ([]SeedConfig, error) {
+	data, err := readSecretFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var seedConfigs []SeedConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &seedConfigs)
+	default:
+		err = json.Unmarshal(data, &seedConfigs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return seedConfigs, nil
+}
+
+// readSeedConfigDir reads one seed configuration from each subdirectory of
+// dir, from files named name, username and password; this is how Kubernetes
+// mounts a secret as a volume.  The database name defaults to the name of the
+// subdirectory.  Hidden entries, such as the ..data links Kubernetes uses to
+// update secrets atomically, are skipped.
+func readSeedConfigDir(dir string) ([]SeedConfig, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		// Follow symbolic links, as mounted secrets are made of them
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var seedConfigs []SeedConfig
+	for _, name := range names {
+		secretDir := filepath.Join(dir, name)
+		seedConfig := SeedConfig{Name: name}
+		fields := []struct {
+			file     string
+			value    *string
+			optional bool
+			secret   bool
+		}{
+			{"name", &seedConfig.Name, true, false},
+			{"username", &seedConfig.Username, false, false},
+			{"password", &seedConfig.Password, false, true},
+		}
+		for _, field := range fields {
+			path := filepath.Join(secretDir, field.file)
+			read := ioutil.ReadFile
+			if field.secret {
+				read = readSecretFile
+			}
+			data, err := read(path)
+			if os.IsNotExist(err) && field.optional {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			// Editors and `echo` leave a trailing newline behind
+			*field.value = strings.TrimRight(string(data), "\r\n")
+		}
+		seedConfigs = append(seedConfigs, seedConfig)
+	}
+	return seedConfigs, nil
+}
+
+// readSecretFile reads a file that may hold passwords, warning if it can be
+// read by any user on the system.
+func readSecretFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0004 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s is world-readable (mode %04o); it may contain passwords\n", path, info.Mode().Perm())
+	}
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of data files
+const (
+	dataFormatCSV = "csv"
+	dataFormatTSV = "tsv"
+)
+
+// SeedData describes a file of rows to load into a table of a seeded
+// database.
+type SeedData struct {
+	Table string `yaml:"table"`
+	// File is a CSV or TSV file, optionally gzipped
+	File string `yaml:"file"`
+	// Format is csv or tsv; by default, it follows the file extension
+	Format string `yaml:"format"`
+	// Header is set if the first line of the file names the columns;
+	// otherwise, the columns are those of the table, in order
+	Header bool `yaml:"header"`
+}
+
+// dataLoaded describes what was loaded from a data file.
+type dataLoaded struct {
+	Table string `json:"table"`
+	File  string `json:"file"`
+	Rows  int64  `json:"rows"`
+	// Skipped is set if the table already had rows
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// dataLoad is a stream of rows to load into a table.
+type dataLoad struct {
+	table   string
+	columns []string
+	rows    dataReader
+}
+
+// dataReader reads the rows of a data file, one at a time, returning io.EOF
+// after the last.  Fields that are NULL are not Valid.  Files are parsed here
+// rather than by the server, so that a file loads the same values whatever
+// the dialect.
+type dataReader interface {
+	Read() ([]sql.NullString, error)
+}
+
+// dataLoader makes bulk loading available for a dialect.  empty returns
+// whether a table has no rows; load streams rows into it, returning how many
+// were loaded.
+type dataLoader struct {
+	empty func(ctx context.Context, db *sql.DB, table string) (bool, error)
+	load  func(ctx context.Context, db *sql.DB, load dataLoad) (int64, error)
+}
+
+// format returns the format of the data file.
+func (d SeedData) format() (string, error) {
+	format := d.Format
+	if format == "" {
+		switch filepath.Ext(strings.TrimSuffix(d.File, ".gz")) {
+		case ".csv":
+			format = dataFormatCSV
+		case ".tsv", ".tab":
+			format = dataFormatTSV
+		}
+	}
+	if format != dataFormatCSV && format != dataFormatTSV {
+		return "", &seedConfigError{Field: "Data", Value: d.File, Reason: "is not a .csv or .tsv file; give the format as csv or tsv"}
+	}
+	return format, nil
+}
+
+// newDataReader returns a reader of rows in the format.  In both formats,
+// lines may end in CRLF, blank lines are skipped, and empty fields are NULL.
+func newDataReader(r io.Reader, format string) dataReader {
+	if format == dataFormatTSV {
+		return &tsvReader{r: bufio.NewReader(r)}
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return csvReader{reader}
+}
+
+// csvReader reads RFC 4180 CSV, in which fields may be quoted, with quotes
+// doubled rather than escaped.
+type csvReader struct {
+	r *csv.Reader
+}
+
+func (c csvReader) Read() ([]sql.NullString, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]sql.NullString, len(record))
+	for i, field := range record {
+		row[i] = sql.NullString{String: field, Valid: field != ""}
+	}
+	return row, nil
+}
+
+// tsvReader reads tab-separated values as MySQL and PostgreSQL write them in
+// text format: quotes are not special, \N is NULL, and a backslash escapes a
+// tab, newline, carriage return or NUL (\t, \n, \r or \0) or itself.
+type tsvReader struct {
+	r *bufio.Reader
+}
+
+func (t *tsvReader) Read() ([]sql.NullString, error) {
+	for {
+		line, err := t.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			continue
+		}
+		var row []sql.NullString
+		for _, field := range strings.Split(line, "\t") {
+			if field == `\N` {
+				row = append(row, sql.NullString{})
+				continue
+			}
+			field = tsvUnescape(field)
+			row = append(row, sql.NullString{String: field, Valid: field != ""})
+		}
+		return row, nil
+	}
+}
+
+// tsvUnescape replaces the backslash escapes in a TSV field.
+func tsvUnescape(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var unescaped strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c == '\\' && i+1 < len(field) {
+			i++
+			switch c = field[i]; c {
+			case 't':
+				c = '\t'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case '0':
+				c = 0
+			}
+		}
+		unescaped.WriteByte(c)
+	}
+	return unescaped.String()
+}
+
+// loadData loads each data file of a seed configuration into its table, as
+// its user, unless the table already has rows.  Files are streamed, so they
+// may be larger than memory.
+func loadData(ctx context.Context, db *connection, seedConfig SeedConfig) ([]dataLoaded, error) {
+	userDB, err := db.openAs(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer userDB.Close()
+
+	var loaded []dataLoaded
+	for _, data := range seedConfig.Data {
+		if data.Table == "" || data.File == "" {
+			return loaded, &seedConfigError{Field: "Data", Value: data.Table + " " + data.File, Reason: "must give both the table and the file"}
+		}
+		result := dataLoaded{Table: data.Table, File: data.File}
+		empty, err := db.dialect.load.empty(ctx, userDB, data.Table)
+		if err != nil {
+			return loaded, fmt.Errorf("table %s: %v", data.Table, err)
+		}
+		if !empty {
+			result.Skipped = true
+		} else {
+			result.Rows, err = loadDataFile(ctx, db.dialect, userDB, data)
+			if err != nil {
+				return loaded, fmt.Errorf("loading %s into table %s: %v", data.File, data.Table, err)
+			}
+		}
+		loaded = append(loaded, result)
+	}
+	return loaded, nil
+}
+
+// loadDataFile streams a data file, decompressing it if needed, into its
+// table.
+func loadDataFile(ctx context.Context, d dialect, db *sql.DB, data SeedData) (int64, error) {
+	format, err := data.format()
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(data.File)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(data.File, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	load := dataLoad{table: data.Table, rows: newDataReader(r, format)}
+	if data.Header {
+		header, err := load.rows.Read()
+		if err != nil {
+			return 0, fmt.Errorf("could not read header: %v", err)
+		}
+		for _, column := range header {
+			load.columns = append(load.columns, strings.TrimSpace(column.String))
+		}
+	}
+	return d.load.load(ctx, db, load)
+}
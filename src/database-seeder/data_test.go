@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readRows reads every row, as strings with NULL written as <nil>.
+func readRows(t *testing.T, rows dataReader) [][]string {
+	var read [][]string
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			return read
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, field := range row {
+			if field.Valid {
+				fields = append(fields, field.String)
+			} else {
+				fields = append(fields, "<nil>")
+			}
+		}
+		read = append(read, fields)
+	}
+}
+
+func TestLoadDataFileCRLF(t *testing.T) {
+	var columns []string
+	var rows [][]string
+	d := dialect{load: dataLoader{load: func(ctx context.Context, db *sql.DB, load dataLoad) (int64, error) {
+		columns = load.columns
+		rows = readRows(t, load.rows)
+		return int64(len(rows)), nil
+	}}}
+
+	n, err := loadDataFile(context.Background(), d, nil, SeedData{Table: "countries", File: "testdata/crlf.csv", Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"code", "name", "note"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns = %q, want %q", columns, want)
+	}
+	want := [][]string{
+		{"DE", "Germany", "<nil>"},
+		{"FR", `France, "la"`, "two\nlines"},
+	}
+	if n != 2 || !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %d %q, want %q", n, rows, want)
+	}
+}
+
+func TestDataReaderTSV(t *testing.T) {
+	input := "DE\t\"Germany\"\t\r\n\nFR\t\\N\tone\\ttwo\\\\three\\nfour\n"
+	got := readRows(t, newDataReader(strings.NewReader(input), dataFormatTSV))
+	want := [][]string{
+		{"DE", `"Germany"`, "<nil>"},
+		{"FR", "<nil>", "one\ttwo\\three\nfour"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+}
+
+func TestMySQLDataStream(t *testing.T) {
+	input := "DE,Germany,\r\nFR,\"tab\there\",\"back\\slash\r\nnew line\"\r\n"
+	stream := mysqlDataStream(newDataReader(strings.NewReader(input), dataFormatCSV))
+	defer stream.Close()
+	got, err := ioutil.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DE\tGermany\t\\N\nFR\ttab\\there\tback\\\\slash\\nnew line\n"
+	if string(got) != want {
+		t.Errorf("stream = %q, want %q", got, want)
+	}
+}
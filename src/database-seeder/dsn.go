@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// connectionOptions describe the server to connect to, from the structured
+// connection flags, as an alternative to giving a DSN.
+type connectionOptions struct {
+	host     string
+	port     string
+	socket   string
+	username string
+	// passwordFile holds the password, so it does not appear on the command
+	// line
+	passwordFile string
+	database     string
+	params       connectionParams
+}
+
+// given returns whether any connection options were given.
+func (o connectionOptions) given() bool {
+	return o.host != "" || o.port != "" || o.socket != "" || o.username != "" ||
+		o.passwordFile != "" || o.database != "" || len(o.params) > 0
+}
+
+// password reads the password from the password file, if there is one.  A
+// trailing newline is ignored.
+func (o connectionOptions) password() (string, error) {
+	if o.passwordFile == "" {
+		return "", nil
+	}
+	data, err := readSecretFile(o.passwordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var connectionParamPattern = regexp.MustCompile(`^\w+$`)
+
+// connectionParams collects repeated -param key=value flags, in order.
+type connectionParams [][2]string
+
+func (p *connectionParams) String() string {
+	var params []string
+	for _, param := range *p {
+		params = append(params, param[0]+"="+param[1])
+	}
+	return strings.Join(params, ",")
+}
+
+func (p *connectionParams) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 || !connectionParamPattern.MatchString(value[:i]) {
+		return fmt.Errorf("%q is not of the form key=value", value)
+	}
+	*p = append(*p, [2]string{value[:i], value[i+1:]})
+	return nil
+}
+
+// buildDSN builds the DSN for the connection options, with the escaping the
+// driver expects.
+func buildDSN(d dialect, options connectionOptions) (string, error) {
+	password, err := options.password()
+	if err != nil {
+		return "", err
+	}
+	if options.host != "" && options.socket != "" {
+		return "", fmt.Errorf("-host and -socket cannot both be given")
+	}
+	return d.buildDSN(options, password)
+}
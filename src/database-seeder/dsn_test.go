@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestBuildDSNDefaultPort(t *testing.T) {
+	options := connectionOptions{host: "db.example.com", username: "admin"}
+
+	dsn, err := mysqlBuildDSN(options, "pw")
+	if want := "admin:pw@tcp(db.example.com:3306)/?allowCleartextPasswords=true&charset=utf8mb4"; err != nil || dsn != want {
+		t.Errorf("mysqlBuildDSN = %q, %v; want %q", dsn, err, want)
+	}
+
+	// lib/pq defaults to port 5432
+	dsn, err = postgresBuildDSN(options, "pw")
+	if want := "host='db.example.com' user='admin' password='pw' dbname='postgres'"; err != nil || dsn != want {
+		t.Errorf("postgresBuildDSN = %q, %v; want %q", dsn, err, want)
+	}
+
+	options.port = "6033"
+	dsn, err = mysqlBuildDSN(options, "pw")
+	if want := "admin:pw@tcp(db.example.com:6033)/?allowCleartextPasswords=true&charset=utf8mb4"; err != nil || dsn != want {
+		t.Errorf("mysqlBuildDSN with a port = %q, %v; want %q", dsn, err, want)
+	}
+	dsn, err = postgresBuildDSN(options, "pw")
+	if want := "host='db.example.com' port='6033' user='admin' password='pw' dbname='postgres'"; err != nil || dsn != want {
+		t.Errorf("postgresBuildDSN with a port = %q, %v; want %q", dsn, err, want)
+	}
+}
@@ -4,5 +4,7 @@ go 1.12
 
 require (
 	github.com/go-sql-driver/mysql v1.4.1
+	github.com/lib/pq v1.3.0
 	google.golang.org/appengine v1.6.2 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// anyHost is the MySQL host pattern matching every host.  Accounts are
+// created for it unless a seed configuration restricts the hosts.
+const anyHost = "%"
+
+// allowedHosts returns the host patterns the user of a seed configuration may
+// connect from.
+func (c SeedConfig) allowedHosts() []string {
+	if len(c.Hosts) == 0 {
+		return []string{anyHost}
+	}
+	return c.Hosts
+}
+
+// applyDefaultHosts restricts the seed configurations that do not list hosts
+// of their own to the given ones.
+func applyDefaultHosts(seedConfigs []SeedConfig, hosts []string) {
+	if len(hosts) == 0 {
+		return
+	}
+	for i := range seedConfigs {
+		if len(seedConfigs[i].Hosts) == 0 {
+			seedConfigs[i].Hosts = hosts
+		}
+	}
+}
+
+// checkUserHosts checks that every seed configuration of a user allows the
+// same hosts, as the accounts of a user are shared between its databases.
+func checkUserHosts(seedConfigs []SeedConfig) error {
+	hostsOf := make(map[string]string)
+	for _, seedConfig := range seedConfigs {
+		hosts := append([]string(nil), seedConfig.allowedHosts()...)
+		sort.Strings(hosts)
+		joined := strings.Join(hosts, ",")
+		if previous, ok := hostsOf[seedConfig.Username]; ok && previous != joined {
+			return &seedConfigError{Field: "Hosts", Value: joined, Reason: "must be the same for every database of user " + seedConfig.Username}
+		}
+		hostsOf[seedConfig.Username] = joined
+	}
+	return nil
+}
+
+// mysqlHostPattern returns the MySQL host pattern for an allowed host.  IPv4
+// CIDR blocks are written as address/netmask, which all servers understand;
+// anything else is taken as a host pattern already.
+func mysqlHostPattern(host string) (string, error) {
+	if host == "" {
+		return "", &seedConfigError{Field: "Hosts", Value: host, Reason: "must not be empty"}
+	}
+	if !strings.Contains(host, "/") {
+		return host, nil
+	}
+	ip, network, err := net.ParseCIDR(host)
+	if err != nil {
+		// Already an address/netmask pair
+		return host, nil
+	}
+	if ip.To4() == nil {
+		return "", &seedConfigError{Field: "Hosts", Value: host, Reason: "must be an IPv4 CIDR block; MySQL has no IPv6 netmasks"}
+	}
+	if !ip.Equal(network.IP) {
+		return "", &seedConfigError{Field: "Hosts", Value: host, Reason: fmt.Sprintf("has host bits set; use %s", network)}
+	}
+	return fmt.Sprintf("%s/%s", network.IP, net.IP(network.Mask)), nil
+}
+
+// mysqlHosts returns the host patterns of the accounts to create for a seed
+// configuration.
+func mysqlHosts(seedConfig SeedConfig) ([]string, error) {
+	var hosts []string
+	for _, host := range seedConfig.allowedHosts() {
+		pattern, err := mysqlHostPattern(host)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, pattern)
+	}
+	return hosts, nil
+}
+
+// containsHost returns whether the host patterns include the given one.
+func containsHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
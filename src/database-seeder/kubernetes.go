@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir is where Kubernetes mounts the pod's service account
+// credentials.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesClient is a minimal client for the Kubernetes API, authenticating
+// with the pod's service account token.  It is only set up when first used, so
+// that it is not an error to run outside Kubernetes without needing it.
+type kubernetesClient struct {
+	// apiURL overrides the in-cluster API server address; this can point at
+	// `kubectl proxy`, or at a fake API server for testing.
+	apiURL string
+	// credentialsDir holds the token, ca.crt and namespace files
+	credentialsDir string
+
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// kubernetesError is an error status returned by the API server.
+type kubernetesError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *kubernetesError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("kubernetes API returned status %d", e.Code)
+	}
+	return fmt.Sprintf("kubernetes API returned status %d: %s", e.Code, e.Message)
+}
+
+func newKubernetesClient(apiURL string) *kubernetesClient {
+	return &kubernetesClient{apiURL: apiURL, credentialsDir: serviceAccountDir}
+}
+
+// setup finds the API server and prepares the HTTP client.
+func (c *kubernetesClient) setup() error {
+	if c.httpClient != nil {
+		return nil
+	}
+
+	apiURL := c.apiURL
+	if apiURL == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return errors.New("not running in Kubernetes (KUBERNETES_SERVICE_HOST is not set); use -kubernetes-api")
+		}
+		apiURL = "https://" + net.JoinHostPort(host, port)
+	}
+	baseURL, err := url.Parse(apiURL)
+	if err != nil {
+		return fmt.Errorf("invalid kubernetes API URL: %v", err)
+	}
+
+	tlsConfig := &tls.Config{}
+	ca, err := ioutil.ReadFile(filepath.Join(c.credentialsDir, "ca.crt"))
+	if err == nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates found in %s", filepath.Join(c.credentialsDir, "ca.crt"))
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	c.baseURL = baseURL
+	c.httpClient = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	return nil
+}
+
+// namespace returns the namespace of the pod.
+func (c *kubernetesClient) namespace() (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.credentialsDir, "namespace"))
+	if err != nil {
+		return "", fmt.Errorf("could not determine the pod's namespace: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// do makes a request of the API server, encoding the body and decoding the
+// result as JSON; PATCH requests are JSON merge patches.  The token is read on
+// every request, as Kubernetes rotates it; without one (such as behind
+// `kubectl proxy`), requests are made without authentication.
+func (c *kubernetesClient) do(ctx context.Context, method, path string, body, result interface{}) error {
+	err := c.setup()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL.String()+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if method == http.MethodPatch {
+		request.Header.Set("Content-Type", "application/merge-patch+json")
+	} else if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	token, err := ioutil.ReadFile(filepath.Join(c.credentialsDir, "token"))
+	if err == nil {
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	} else if !os.IsNotExist(err) || c.apiURL == "" {
+		return fmt.Errorf("could not read service account token: %v", err)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		status := &kubernetesError{}
+		json.NewDecoder(response.Body).Decode(status)
+		status.Code = response.StatusCode
+		return status
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// isNotFound returns whether the API server reported that something does not
+// exist.
+func isNotFound(err error) bool {
+	var status *kubernetesError
+	return errors.As(err, &status) && status.Code == http.StatusNotFound
+}
+
+// secretsPath returns the API path of the secrets in a namespace.
+func secretsPath(namespace string) string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/secrets", url.PathEscape(namespace))
+}
+
+// secretPath returns the API path of a secret.
+func secretPath(namespace, name string) string {
+	return secretsPath(namespace) + "/" + url.PathEscape(name)
+}
+
+// getSecret returns the (decoded) data of a secret.
+func (c *kubernetesClient) getSecret(ctx context.Context, namespace, name string) (map[string][]byte, error) {
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, secretPath(namespace, name), nil, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret %s/%s: %w", namespace, name, err)
+	}
+	return secret.Data, nil
+}
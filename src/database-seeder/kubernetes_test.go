@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKubernetes is a fake API server holding secrets, which only accepts
+// requests with the given token, if there is one.
+type fakeKubernetes struct {
+	token string
+
+	mu       sync.Mutex
+	secrets  map[string]map[string][]byte
+	requests []string
+}
+
+func (f *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	fail := func(code int, reason, message string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "Status", "code": code, "reason": reason, "message": message})
+	}
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		fail(http.StatusUnauthorized, "Unauthorized", "Unauthorized")
+		return
+	}
+
+	// /api/v1/namespaces/NAMESPACE/secrets[/NAME]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+	if len(parts) < 2 || parts[1] != "secrets" {
+		fail(http.StatusNotFound, "NotFound", "unknown path")
+		return
+	}
+	namespace := parts[0]
+
+	var body struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		StringData map[string]string `json:"stringData"`
+	}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2:
+		key := namespace + "/" + body.Metadata.Name
+		if _, ok := f.secrets[key]; ok {
+			fail(http.StatusConflict, "AlreadyExists", "secret exists")
+			return
+		}
+		data := make(map[string][]byte)
+		for k, v := range body.StringData {
+			data[k] = []byte(v)
+		}
+		f.secrets[key] = data
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	case len(parts) == 3:
+		key := namespace + "/" + parts[2]
+		data, ok := f.secrets[key]
+		if !ok {
+			fail(http.StatusNotFound, "NotFound", `secrets "`+parts[2]+`" not found`)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPatch:
+			if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+				fail(http.StatusUnsupportedMediaType, "UnsupportedMediaType", "not a merge patch")
+				return
+			}
+			for k, v := range body.StringData {
+				data[k] = []byte(v)
+			}
+		default:
+			fail(http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	default:
+		fail(http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// newFakeKubernetes starts a fake API server, and returns a client for it
+// whose service account credentials hold the token and namespace, if given,
+// and a function that stops it.
+func newFakeKubernetes(t *testing.T, token, namespace string) (*fakeKubernetes, *kubernetesClient, func()) {
+	fake := &fakeKubernetes{token: token, secrets: make(map[string]map[string][]byte)}
+	server := httptest.NewServer(fake)
+
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+	for name, content := range map[string]string{"token": token, "namespace": namespace} {
+		if content == "" {
+			continue
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content+"\n"), 0600)
+		if err != nil {
+			stop()
+			t.Fatal(err)
+		}
+	}
+
+	client := newKubernetesClient(server.URL)
+	client.credentialsDir = dir
+	return fake, client, stop
+}
+
+func TestKubernetesSecrets(t *testing.T) {
+	fake, client, stop := newFakeKubernetes(t, "test-token", "pod-ns")
+	defer stop()
+	fake.secrets["pod-ns/db"] = map[string][]byte{"password": []byte("from-pod-ns")}
+	fake.secrets["other/db"] = map[string][]byte{"password": []byte("from-other")}
+
+	secrets := &kubernetesSecrets{client: client}
+	ctx := context.Background()
+	for reference, want := range map[string]string{
+		"db#password":       "from-pod-ns",
+		"other/db#password": "from-other",
+	} {
+		got, err := secrets.Resolve(ctx, reference)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", reference, got, err, want)
+		}
+	}
+
+	_, err := secrets.Resolve(ctx, "db#missing")
+	if err == nil || !strings.Contains(err.Error(), "has no key missing") {
+		t.Errorf("Resolve with a missing key: %v", err)
+	}
+	_, err = secrets.Resolve(ctx, "absent#password")
+	if !isNotFound(err) {
+		t.Errorf("Resolve of a missing secret: %v, want not found", err)
+	}
+
+	// Secrets are only fetched once
+	gets := 0
+	for _, request := range fake.requests {
+		if request == "GET /api/v1/namespaces/pod-ns/secrets/db" {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("secret fetched %d times: %v", gets, fake.requests)
+	}
+}
+
+func TestKubernetesAuthentication(t *testing.T) {
+	fake, client, stop := newFakeKubernetes(t, "test-token", "pod-ns")
+	defer stop()
+	fake.secrets["pod-ns/db"] = map[string][]byte{"password": []byte("pw")}
+
+	// The token is read on every request, as Kubernetes rotates it
+	err := ioutil.WriteFile(filepath.Join(client.credentialsDir, "token"), []byte("stale-token"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.getSecret(context.Background(), "pod-ns", "db")
+	var status *kubernetesError
+	if !errors.As(err, &status) || status.Code != http.StatusUnauthorized || status.Message != "Unauthorized" {
+		t.Errorf("getSecret with a bad token: %v", err)
+	}
+
+	// Without a token, as behind kubectl proxy, requests are unauthenticated
+	fake.token = ""
+	os.Remove(filepath.Join(client.credentialsDir, "token"))
+	data, err := client.getSecret(context.Background(), "pod-ns", "db")
+	if err != nil || string(data["password"]) != "pw" {
+		t.Errorf("getSecret without a token = %q, %v", data, err)
+	}
+
+	// In the cluster, the token is required
+	inCluster := newKubernetesClient("")
+	inCluster.credentialsDir = client.credentialsDir
+	inCluster.baseURL, inCluster.httpClient = client.baseURL, client.httpClient
+	_, err = inCluster.getSecret(context.Background(), "pod-ns", "db")
+	if err == nil || !strings.Contains(err.Error(), "service account token") {
+		t.Errorf("getSecret in the cluster without a token: %v", err)
+	}
+}
+
+func TestKubernetesPasswordStore(t *testing.T) {
+	fake, client, stop := newFakeKubernetes(t, "test-token", "pod-ns")
+	defer stop()
+	store, err := newPasswordStore("k8s-secret:passwords", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	passwords, err := store.Load(ctx)
+	if err != nil || len(passwords) != 0 {
+		t.Fatalf("Load of a missing secret = %v, %v", passwords, err)
+	}
+
+	// The secret is created when patching finds it missing
+	err = store.Save(ctx, map[string]string{"app": "pw1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GET /api/v1/namespaces/pod-ns/secrets/passwords",
+		"PATCH /api/v1/namespaces/pod-ns/secrets/passwords",
+		"POST /api/v1/namespaces/pod-ns/secrets",
+	}
+	if !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %v, want %v", fake.requests, want)
+	}
+
+	// Later passwords are merged into it
+	err = store.Save(ctx, map[string]string{"other": "pw2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords, err = store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"app": "pw1", "other": "pw2"}; !reflect.DeepEqual(passwords, want) {
+		t.Errorf("Load = %v, want %v", passwords, want)
+	}
+	if len(fake.secrets) != 1 {
+		t.Errorf("secrets = %v", fake.secrets)
+	}
+}
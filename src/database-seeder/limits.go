@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SeedLimits caps the resources a user may use.  Limits left out are left as
+// they are on the server; a limit of 0 removes it.
+type SeedLimits struct {
+	// MaxUserConnections caps the connections open at once: on PostgreSQL,
+	// the CONNECTION LIMIT of the role
+	MaxUserConnections *int64 `yaml:"max_user_connections" json:"max_user_connections"`
+	// MaxQueriesPerHour and MaxUpdatesPerHour are only supported on MySQL
+	MaxQueriesPerHour *int64 `yaml:"max_queries_per_hour" json:"max_queries_per_hour"`
+	MaxUpdatesPerHour *int64 `yaml:"max_updates_per_hour" json:"max_updates_per_hour"`
+}
+
+// resourceLimit is a limit set for a user, as the clause that sets it and the
+// values wanted and on the server, as the server writes them.
+type resourceLimit struct {
+	Clause  string
+	Wanted  int64
+	Current int64
+}
+
+// String returns the clause setting the limit.
+func (l resourceLimit) String() string {
+	return fmt.Sprintf("%s %d", l.Clause, l.Wanted)
+}
+
+// limitClause returns the clauses setting the limits, each with a leading
+// space.
+func limitClause(limits []resourceLimit) string {
+	clause := ""
+	for _, limit := range limits {
+		clause += " " + limit.String()
+	}
+	return clause
+}
+
+// changedLimits returns the limits that differ from those on the server.
+func changedLimits(limits []resourceLimit) []resourceLimit {
+	var changed []resourceLimit
+	for _, limit := range limits {
+		if limit.Wanted != limit.Current {
+			changed = append(changed, limit)
+		}
+	}
+	return changed
+}
+
+// limitDrift describes the limits of an account that differ from those
+// wanted, as drift findings.
+func limitDrift(limits []resourceLimit, account string) []driftFinding {
+	var findings []driftFinding
+	for _, limit := range changedLimits(limits) {
+		findings = append(findings, driftFinding{
+			Kind:   driftLimit,
+			Detail: fmt.Sprintf("%s of %s is %d rather than %d", limit.Clause, account, limit.Current, limit.Wanted),
+		})
+	}
+	return findings
+}
+
+// joinLimits lists the limits for plan output.
+func joinLimits(limits []resourceLimit) string {
+	var clauses []string
+	for _, limit := range limits {
+		clauses = append(clauses, limit.String())
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// validateLimits rejects negative limits.
+func validateLimits(limits SeedLimits) error {
+	for _, limit := range []struct {
+		name  string
+		value *int64
+	}{
+		{"max_user_connections", limits.MaxUserConnections},
+		{"max_queries_per_hour", limits.MaxQueriesPerHour},
+		{"max_updates_per_hour", limits.MaxUpdatesPerHour},
+	} {
+		if limit.value != nil && *limit.value < 0 {
+			return &seedConfigError{Field: "Limits", Value: fmt.Sprintf("%s %d", limit.name, *limit.value), Reason: "must not be negative; use 0 for no limit"}
+		}
+	}
+	return nil
+}
+
+// checkUserLimits checks that every seed configuration of a user sets the same
+// limits, as they belong to the user rather than a database.
+func checkUserLimits(seedConfigs []SeedConfig) error {
+	limitsOf := make(map[string]string)
+	for _, seedConfig := range seedConfigs {
+		limits := seedConfig.Limits.String()
+		if previous, ok := limitsOf[seedConfig.Username]; ok && previous != limits {
+			return &seedConfigError{Field: "Limits", Value: limits, Reason: "must be the same for every database of user " + seedConfig.Username}
+		}
+		limitsOf[seedConfig.Username] = limits
+	}
+	return nil
+}
+
+// String describes the limits that are set.
+func (l SeedLimits) String() string {
+	var limits []string
+	add := func(name string, value *int64) {
+		if value != nil {
+			limits = append(limits, fmt.Sprintf("%s %d", name, *value))
+		}
+	}
+	add("max_user_connections", l.MaxUserConnections)
+	add("max_queries_per_hour", l.MaxQueriesPerHour)
+	add("max_updates_per_hour", l.MaxUpdatesPerHour)
+	if len(limits) == 0 {
+		return "none"
+	}
+	return strings.Join(limits, ", ")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The example from the README, as the BOSH job and SEEDER_CONFIGS pass it
+const limitsExample = `[{"name": "db1", "username": "app", "password": "...", "limits": {"max_user_connections": 50, "max_queries_per_hour": 100000}}]`
+
+func TestLimitsDecodeFromJSON(t *testing.T) {
+	seedConfigs, err := loadSeedConfigs(seedConfigSources{inline: limitsExample})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "max_user_connections 50, max_queries_per_hour 100000"
+	if got := seedConfigs[0].Limits.String(); got != want {
+		t.Errorf("limits = %q, want %q", got, want)
+	}
+}
+
+func TestLimitsDecodeFromFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"seeded_databases.json": limitsExample,
+		"seeded_databases.yml": "- name: db1\n  username: app\n  password: '...'\n" +
+			"  users:\n  - username: other\n    limits: {max_user_connections: 5, max_updates_per_hour: 0}\n",
+	}
+	want := map[string]string{
+		"seeded_databases.json": "max_user_connections 50, max_queries_per_hour 100000",
+		"seeded_databases.yml":  "max_user_connections 5, max_updates_per_hour 0",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seedConfigs, err := loadSeedConfigs(seedConfigSources{file: path})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expanded, err := expandSeedConfigs(seedConfigs, false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := expanded[len(expanded)-1].Limits.String(); got != want[name] {
+			t.Errorf("%s: limits = %q, want %q", name, got, want[name])
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// lockName names the advisory lock held by the seeder that is making changes.
+const lockName = bookkeepingSchema
+
+// advisoryLock makes a server-wide advisory lock available for a dialect.
+// acquire waits up to the timeout for the lock on the given connection,
+// returning whether it was taken; a timeout of zero only tries once.  The lock
+// is held until release is run or the connection closes.
+type advisoryLock struct {
+	acquire func(ctx context.Context, conn *sql.Conn, timeout time.Duration) (bool, error)
+	release string
+}
+
+// seederLock is the advisory lock held while seeding, on a connection of its
+// own so that it does not take one from the pool.
+type seederLock struct {
+	db   *sql.DB
+	conn *sql.Conn
+	lock advisoryLock
+	// timeout is how long to wait for the lock when taking it again
+	timeout time.Duration
+	once    sync.Once
+}
+
+// acquireLock takes the advisory lock, waiting up to the timeout while another
+// seeder holds it.  It returns whether it had to wait.
+func acquireLock(ctx context.Context, d dialect, dsn string, timeout time.Duration) (*seederLock, bool, error) {
+	db, err := d.open(dsn)
+	if err != nil {
+		return nil, false, err
+	}
+	db.SetMaxOpenConns(1)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+
+	waited := false
+	acquired, err := d.lock.acquire(ctx, conn, 0)
+	if err == nil && !acquired && timeout > 0 {
+		waited = true
+		fmt.Printf("Another seeder is running; waiting up to %s for it to finish...\n", timeout)
+		acquired, err = d.lock.acquire(ctx, conn, timeout)
+	}
+	if err == nil && !acquired {
+		err = fmt.Errorf("another seeder still holds the lock after %s", timeout)
+	}
+	if err != nil {
+		conn.Close()
+		db.Close()
+		return nil, waited, err
+	}
+	return &seederLock{db: db, conn: conn, lock: d.lock, timeout: timeout}, waited, nil
+}
+
+// pause releases the lock while waiting, so that other seeders can run in the
+// meantime, and then takes it again.
+func (l *seederLock) pause(ctx context.Context, wait time.Duration) error {
+	_, err := l.conn.ExecContext(ctx, l.lock.release)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	acquired, err := l.lock.acquire(ctx, l.conn, l.timeout)
+	if err == nil && !acquired {
+		err = fmt.Errorf("another seeder still holds the lock after %s", l.timeout)
+	}
+	return err
+}
+
+// Release releases the lock, if it is held.  Should that fail, closing the
+// connection releases it all the same.
+func (l *seederLock) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := l.conn.ExecContext(ctx, l.lock.release)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error releasing the seeder lock: %v\n", err)
+		}
+		l.conn.Close()
+		l.db.Close()
+	})
+}
+
+// releaseOnSignal cancels whatever is in progress, releases the lock and exits
+// when the seeder is interrupted or terminated.
+func releaseOnSignal(cancel context.CancelFunc, lock *seederLock) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Fprintf(os.Stderr, "Received %s; releasing the seeder lock\n", sig)
+		cancel()
+		lock.Release()
+		os.Exit(1)
+	}()
+}
+
+// checkOtherSeeder checks, once another seeder has finished, whether it left
+// the databases and users in place, so that they need not be seeded again.
+func checkOtherSeeder(ctx context.Context, db *connection, seedConfigs []SeedConfig) bool {
+	fmt.Printf("Checking the databases seeded by the other seeder...\n")
+	drifts, drifted, hasError := checkDrift(ctx, db, seedConfigs)
+	if drifted || hasError {
+		fmt.Printf("They differ from the configuration; seeding them again:\n")
+		writeDrift(os.Stdout, drifts, "text")
+		return false
+	}
+	fmt.Printf("Their users are in place; only applying any migrations and data left to do.\n")
+	return true
+}
@@ -3,101 +3,567 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 )
 
-// SeedConfig describes the structure for database seeding configuration
+// SeedConfig describes the structure for database seeding configuration.  The
+// user may be given directly, and further users may be listed in Users.
 type SeedConfig struct {
-	Name     string
-	Username string
-	Password string
+	Name     string `yaml:"name"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Charset and Collation set the default character set and collation of
+	// the database; on PostgreSQL, its encoding and locale (LC_COLLATE and
+	// LC_CTYPE).  The server defaults are used if empty.
+	Charset   string `yaml:"charset"`
+	Collation string `yaml:"collation"`
+	// Template names a database to copy when creating the database.
+	Template string `yaml:"template"`
+	// Migrations is a directory of versioned .sql files to apply to the
+	// database, as its first user, once its users have been seeded.
+	Migrations string `yaml:"migrations"`
+	// Data lists files of rows to load into tables of the database, after
+	// its migrations, as its first user.
+	Data []SeedData `yaml:"data"`
+
+	// Profile names the set of privileges to grant: owner (the default),
+	// readwrite or readonly.
+	Profile string `yaml:"profile"`
+	// Privileges lists the privileges to grant, instead of a profile.
+	Privileges []string `yaml:"privileges"`
+	// Hosts lists the host patterns or IPv4 CIDR blocks the user may connect
+	// from, on MySQL; any host by default.
+	Hosts []string `yaml:"hosts"`
+	// Limits caps the resources the user may use.
+	Limits SeedLimits `yaml:"limits"`
+
+	// Users lists additional users to give access to the database.
+	Users []SeedUser `yaml:"users"`
 }
 
-type dbCreator func(*sql.DB, SeedConfig) error
+// SeedUser describes an additional user of a seeded database
+type SeedUser struct {
+	Username   string     `yaml:"username"`
+	Password   string     `yaml:"password"`
+	Profile    string     `yaml:"profile"`
+	Privileges []string   `yaml:"privileges"`
+	Hosts      []string   `yaml:"hosts"`
+	Limits     SeedLimits `yaml:"limits"`
+}
 
-func mysqlCreator(db *sql.DB, seedConfig SeedConfig) (err error) {
+// isOwner returns whether the user gets the owner profile.
+func (u SeedUser) isOwner() bool {
+	return len(u.Privileges) == 0 && (u.Profile == "" || u.Profile == profileOwner)
+}
 
-	exec := func(stmt string, args ...interface{}) (sql.Result, error) {
-		finalStmt := fmt.Sprintf(stmt, args...)
-		// fmt.Printf("%s\n", finalStmt)
-		return db.Exec(finalStmt)
-	}
+// expandSeedConfigs splits the seed configurations into one per database and
+// user, so that each describes a single user.  Users with the owner profile
+// come first, so that any ownership is settled before other users are given
+// access.
+func expandSeedConfigs(seedConfigs []SeedConfig, singleOwner bool) ([]SeedConfig, error) {
+	var expanded []SeedConfig
+	for _, seedConfig := range seedConfigs {
+		if seedConfig.Template != "" && seedConfig.Template == seedConfig.Name {
+			return nil, &seedConfigError{Field: "Template", Value: seedConfig.Template, Reason: "must not be the database itself"}
+		}
+		users := seedConfig.Users
+		if seedConfig.Username != "" {
+			users = append([]SeedUser{{
+				Username:   seedConfig.Username,
+				Password:   seedConfig.Password,
+				Profile:    seedConfig.Profile,
+				Privileges: seedConfig.Privileges,
+				Hosts:      seedConfig.Hosts,
+				Limits:     seedConfig.Limits,
+			}}, users...)
+		}
+		if len(users) == 0 {
+			return nil, &seedConfigError{Field: "Users", Value: seedConfig.Name, Reason: "no users given for database"}
+		}
 
-	// Create the database
-	_, err = exec("CREATE DATABASE IF NOT EXISTS `%s`", seedConfig.Name)
-	if err != nil {
-		return err
-	}
+		var owners, others []SeedUser
+		seen := make(map[string]bool)
+		for _, user := range users {
+			if seen[user.Username] {
+				return nil, &seedConfigError{Field: "Users", Value: user.Username, Reason: "user is listed more than once for database " + seedConfig.Name}
+			}
+			seen[user.Username] = true
+			if user.isOwner() {
+				owners = append(owners, user)
+			} else {
+				others = append(others, user)
+			}
+		}
+		if singleOwner && len(owners) > 1 {
+			return nil, &seedConfigError{Field: "Users", Value: seedConfig.Name, Reason: "only one user may have the owner profile"}
+		}
 
-	// Grant privileges (implicitly creates or updates credentials as needed)
-	_, err = exec("GRANT ALL ON `%s`.* TO `%s`@`%%` IDENTIFIED BY '%s'", seedConfig.Name, seedConfig.Username, seedConfig.Password)
-	if err != nil {
-		return err
+		for _, user := range append(owners, others...) {
+			expanded = append(expanded, SeedConfig{
+				Name:       seedConfig.Name,
+				Charset:    seedConfig.Charset,
+				Collation:  seedConfig.Collation,
+				Template:   seedConfig.Template,
+				Migrations: seedConfig.Migrations,
+				Data:       seedConfig.Data,
+				Username:   user.Username,
+				Password:   user.Password,
+				Profile:    user.Profile,
+				Privileges: user.Privileges,
+				Hosts:      user.Hosts,
+				Limits:     user.Limits,
+			})
+		}
 	}
+	return expanded, nil
+}
+
+// dbCreator seeds a single database and user, returning what was changed.
+type dbCreator func(context.Context, *connection, SeedConfig) (seedChanges, error)
+
+// connection is the seeding connection to the database server; it can also
+// open further connections to individual databases on the same server.
+type connection struct {
+	*sql.DB
+	dialect dialect
+	dsn     string
+	// rotating lists the users whose passwords are being rotated
+	rotating map[string]time.Time
+	// alterCharset is set if existing databases should be altered to the
+	// character set and collation asked for, rather than only reported
+	alterCharset bool
+	// wildcardHosts is set if check should report seeded accounts for
+	// wildcard hosts too
+	wildcardHosts bool
+	// templateSizeLimit is the largest template database, in bytes, to copy
+	// table by table; there is no limit if it is 0
+	templateSizeLimit int64
+}
 
-	_, err = exec("REVOKE LOCK TABLES ON `%s`.* FROM `%s`@`%%`", seedConfig.Name, seedConfig.Username)
+// openDatabase opens a new connection to the named database, as the seeding
+// user.
+func (c *connection) openDatabase(database string) (*sql.DB, error) {
+	dsn, err := c.dialect.dsnFor(c.dsn, database)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return c.dialect.open(dsn)
+}
 
-	return nil
+// queryer is implemented by both *sql.DB and *sql.Tx, so that server
+// inspection can be shared between seeding and read-only planning.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// dialect collects the operations supported for a database driver.
+type dialect struct {
+	// singleOwner is set if only one user can own a database
+	singleOwner bool
+
+	open           func(dsn string) (*sql.DB, error)
+	dsnFor         func(dsn, database string) (string, error)
+	dsnAs          func(dsn, database, username, password string) (string, error)
+	buildDSN       func(options connectionOptions, password string) (string, error)
+	withTLS        func(dsn string, options tlsOptions) (string, error)
+	permanentError func(error) bool
+
+	create      dbCreator
+	plan        dbPlanner
+	prune       dbPruner
+	verify      dbVerifier
+	check       dbChecker
+	bookkeeping bookkeepingSQL
+	rotate      passwordRotator
+	migrations  migrationSQL
+	load        dataLoader
+	lock        advisoryLock
+}
+
+var dialects = map[string]dialect{
+	"mysql": {
+		open:           openMySQL,
+		dsnFor:         mysqlDSNFor,
+		dsnAs:          mysqlDSNAs,
+		buildDSN:       mysqlBuildDSN,
+		withTLS:        mysqlWithTLS,
+		permanentError: mysqlPermanentError,
+		create:         mysqlCreator,
+		plan:           mysqlPlanner,
+		prune:          mysqlPruner,
+		verify:         mysqlVerifier,
+		check:          mysqlChecker,
+		bookkeeping:    mysqlBookkeeping,
+		rotate:         mysqlRotator,
+		migrations:     mysqlMigrations,
+		load:           mysqlLoader,
+		lock:           mysqlLock,
+	},
+	"postgres": {
+		singleOwner:    true,
+		open:           openPostgres,
+		dsnFor:         postgresDSNFor,
+		dsnAs:          postgresDSNAs,
+		buildDSN:       postgresBuildDSN,
+		withTLS:        postgresWithTLS,
+		permanentError: postgresPermanentError,
+		create:         postgresCreator,
+		plan:           postgresPlanner,
+		prune:          postgresPruner,
+		verify:         postgresVerifier,
+		check:          postgresChecker,
+		bookkeeping:    postgresBookkeeping,
+		rotate:         postgresRotator,
+		migrations:     postgresMigrations,
+		load:           postgresLoader,
+		lock:           postgresLock,
+	},
 }
 
 func main() {
-	var driver, dsn, seedConfigsJSON string
+	var driver, dsn, planFormat, kubernetesAPI, passwordStoreLocation string
+	var sources seedConfigSources
+	var plan, prune, pruneDatabases, verify, alterCharset, wildcardHosts bool
+	var parallelism int
+	var templateSizeLimit int64
+	var lockTimeout time.Duration
+	var reports reportPaths
+	var retry retryConfig
+	var tlsFlags tlsOptions
+	var server connectionOptions
+	var rotation rotateOptions
+	var rotateUsers, defaultHosts, defaultCharset, defaultCollation string
+
+	// The rotate subcommand rotates passwords, and the check subcommand
+	// reports drift, rather than seeding
+	command, args := "seed", os.Args[1:]
+	if len(args) > 0 && (args[0] == "rotate" || args[0] == "check") {
+		command, args = args[0], args[1:]
+	}
 
 	flag.StringVar(&driver, "driver", "mysql", "Database driver to use")
-	flag.StringVar(&dsn, "dsn", "", "Database connection string (DSN) to use (SEEDER_DSN)")
-	flag.StringVar(&seedConfigsJSON, "seed-configs", "", "Database seeding configuration, as a JSON string (SEEDER_CONFIGS)")
-	flag.Parse()
+	flag.StringVar(&dsn, "dsn", "", "Database connection string (DSN) to use, instead of the flags below (SEEDER_DSN)")
+	flag.StringVar(&server.host, "host", "", "Host name of the database server")
+	flag.StringVar(&server.port, "port", "", "Port of the database server")
+	flag.StringVar(&server.socket, "socket", "", "Unix socket of the database server (for postgres, the directory holding it)")
+	flag.StringVar(&server.username, "username", "", "User name to connect as")
+	flag.StringVar(&server.passwordFile, "password-file", "", "File holding the password to connect with")
+	flag.StringVar(&server.database, "database", "", "Database to connect to")
+	flag.Var(&server.params, "param", "Connection parameter for the driver, as key=value; may be repeated")
+	flag.StringVar(&tlsFlags.ca, "tls-ca", "", "PEM file of the certificate authorities to verify the server certificate with")
+	flag.StringVar(&tlsFlags.cert, "tls-cert", "", "PEM file of the client certificate to present")
+	flag.StringVar(&tlsFlags.key, "tls-key", "", "PEM file of the key for -tls-cert")
+	flag.StringVar(&tlsFlags.serverName, "tls-server-name", "", "Name to verify the server certificate against, if not the host name (mysql only)")
+	flag.StringVar(&tlsFlags.minVersion, "tls-min-version", "", "Oldest TLS version to accept: 1.0, 1.1, 1.2 or 1.3 (mysql only)")
+	flag.StringVar(&sources.inline, "seed-configs", "", "Database seeding configuration, as a JSON string (SEEDER_CONFIGS)")
+	flag.StringVar(&sources.file, "seed-config-file", "", "Read database seeding configuration from this JSON or YAML file")
+	flag.StringVar(&sources.dir, "seed-config-dir", "", "Read database seeding configuration from the name, username and password files in each subdirectory of this directory")
+	flag.StringVar(&defaultHosts, "default-hosts", "", "Comma-separated host patterns or IPv4 CIDR blocks users may connect from, unless their seed config lists hosts (mysql only)")
+	flag.StringVar(&defaultCharset, "default-charset", "", "Character set (postgres: encoding) of databases whose seed config names neither one nor a collation")
+	flag.StringVar(&defaultCollation, "default-collation", "", "Collation (postgres: locale) of databases whose seed config names neither one nor a character set")
+	flag.BoolVar(&alterCharset, "alter-charset", false, "Alter existing databases to the character set and collation asked for, rather than only reporting them (mysql only)")
+	flag.StringVar(&kubernetesAPI, "kubernetes-api", "", "URL of the Kubernetes API server for k8s-secret: passwords, if not the in-cluster one")
+	flag.StringVar(&passwordStoreLocation, "generate-passwords", "", "Generate passwords for users without one, keeping them in file:PATH or k8s-secret:NAMESPACE/NAME")
+	flag.BoolVar(&plan, "plan", false, "Only print the changes that would be made, without making them")
+	flag.StringVar(&planFormat, "plan-format", "text", "Output format for -plan and check; either text or json")
+	flag.BoolVar(&prune, "prune", false, "Revoke or drop users of previously seeded databases that are no longer listed")
+	flag.BoolVar(&pruneDatabases, "prune-databases", false, "With -prune, also drop previously seeded databases that are no longer listed")
+	flag.BoolVar(&verify, "verify", false, "After seeding, log in as each user to check that it can use its database as intended")
+	flag.Int64Var(&templateSizeLimit, "template-size-limit", 1024, "Largest template database, in MiB, to copy table by table (mysql only); 0 for no limit")
+	flag.IntVar(&parallelism, "parallelism", 1, "Number of databases to seed at once")
+	flag.DurationVar(&retry.timeout, "wait-timeout", 5*time.Minute, "How long to keep retrying while the database server is not ready; 0 to only try once")
+	flag.DurationVar(&retry.initialDelay, "retry-delay", time.Second, "Initial delay between connection attempts; doubles with each attempt")
+	flag.DurationVar(&retry.maxDelay, "retry-max-delay", 30*time.Second, "Maximum delay between connection attempts")
+	flag.DurationVar(&lockTimeout, "lock-timeout", 10*time.Minute, "How long to wait while another seeder holds the lock; 0 to fail at once")
+	flag.StringVar(&reports.json, "report", "", "Write a JSON report of the seeding results to this file")
+	flag.StringVar(&reports.junit, "junit-report", "", "Write a JUnit XML report of the seeding results to this file")
+	flag.BoolVar(&rotation.finish, "finish", false, "rotate: Retire the old passwords of rotations already begun")
+	flag.DurationVar(&rotation.gracePeriod, "grace-period", 0, "rotate: Retire the old passwords this long after beginning, rather than waiting for -finish")
+	flag.DurationVar(&rotation.retireAfter, "retire-after", 24*time.Hour, "rotate: How long the second PostgreSQL login role stays usable once a rotation finishes")
+	flag.BoolVar(&wildcardHosts, "report-wildcard-hosts", false, "check: Also report seeded accounts for wildcard hosts, such as '%' (mysql only)")
+	flag.StringVar(&rotateUsers, "users", "", "rotate: Comma-separated users whose passwords to rotate; all by default")
+	flag.CommandLine.Parse(args)
 
-	if dsn == "" {
-		dsn = os.Getenv("SEEDER_DSN")
+	if command != "seed" && plan {
+		fmt.Fprintf(os.Stderr, "-plan cannot be used with %s\n", command)
+		os.Exit(1)
+	}
+	if command != "rotate" && (rotation.finish || rotation.gracePeriod != 0 || rotateUsers != "") {
+		fmt.Fprintf(os.Stderr, "-finish, -grace-period and -users can only be used with rotate\n")
+		os.Exit(1)
+	}
+	if command != "check" && wildcardHosts {
+		fmt.Fprintf(os.Stderr, "-report-wildcard-hosts can only be used with check\n")
+		os.Exit(1)
+	}
+	if rotateUsers != "" {
+		rotation.users = make(map[string]bool)
+		for _, username := range strings.Split(rotateUsers, ",") {
+			rotation.users[strings.TrimSpace(username)] = true
+		}
+	}
+
+	if planFormat != "text" && planFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown plan format %s\n", planFormat)
+		os.Exit(1)
+	}
+
+	if retry.initialDelay <= 0 || retry.maxDelay < retry.initialDelay {
+		fmt.Fprintf(os.Stderr, "-retry-delay must be positive, and no more than -retry-max-delay\n")
+		os.Exit(1)
 	}
-	if seedConfigsJSON == "" {
-		seedConfigsJSON = os.Getenv("SEEDER_CONFIGS")
+
+	if parallelism < 1 {
+		fmt.Fprintf(os.Stderr, "-parallelism must be at least 1\n")
+		os.Exit(1)
 	}
 
-	var seedConfigs []SeedConfig
-	err := json.Unmarshal([]byte(seedConfigsJSON), &seedConfigs)
+	if pruneDatabases && !prune {
+		fmt.Fprintf(os.Stderr, "-prune-databases requires -prune\n")
+		os.Exit(1)
+	}
+
+	if sources.inline == "" {
+		sources.inline = os.Getenv("SEEDER_CONFIGS")
+	}
+
+	seedConfigs, err := loadSeedConfigs(sources)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not parse seed configs: %v\n", err)
 		os.Exit(1)
 	}
 
-	db, err := sql.Open(driver, dsn)
+	dialect, ok := dialects[driver]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error locating db creator for driver %s\n", driver)
+		os.Exit(1)
+	}
+
+	// An explicit -dsn overrides the connection flags, which in turn override
+	// SEEDER_DSN
+	if dsn == "" && server.given() {
+		dsn, err = buildDSN(dialect, server)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid connection options: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if dsn == "" {
+		dsn = os.Getenv("SEEDER_DSN")
+	}
+
+	if tlsFlags.enabled() {
+		err = tlsFlags.validate()
+		if err == nil {
+			dsn, err = dialect.withTLS(dsn, tlsFlags)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid TLS configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	seedConfigs, err = expandSeedConfigs(seedConfigs, dialect.singleOwner)
+	if err == nil && defaultHosts != "" {
+		var hosts []string
+		for _, host := range strings.Split(defaultHosts, ",") {
+			hosts = append(hosts, strings.TrimSpace(host))
+		}
+		applyDefaultHosts(seedConfigs, hosts)
+	}
+	if err == nil {
+		applyDefaultCharset(seedConfigs, defaultCharset, defaultCollation)
+		err = checkUserHosts(seedConfigs)
+	}
+	if err == nil {
+		err = checkUserLimits(seedConfigs)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error connecting to database: %s\n", err)
+		fmt.Fprintf(os.Stderr, "Invalid seed configs: %v\n", err)
 		os.Exit(1)
 	}
 
-	creator := map[string]dbCreator{
-		"mysql": mysqlCreator,
-	}[driver]
-	if creator == nil {
-		fmt.Fprintf(os.Stderr, "Error locating db creator for driver %s\n", driver)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kubernetes := newKubernetesClient(kubernetesAPI)
+	err = resolvePasswords(ctx, seedConfigs, secretProviders(kubernetes))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid seed configs: %v\n", err)
 		os.Exit(1)
 	}
 
-	hasError := false
+	var store passwordStore
+	if passwordStoreLocation != "" {
+		store, err = newPasswordStore(passwordStoreLocation, kubernetes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating passwords: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	for _, seedConfig := range seedConfigs {
-		fmt.Printf("Seeding database %s (user %s)...\n", seedConfig.Name, seedConfig.Username)
-		err = creator(db, seedConfig)
+	start := time.Now()
+	var lock *seederLock
+	// exit releases the seeder lock, if held, and exits
+	exit := func(code int) {
+		lock.Release()
+		os.Exit(code)
+	}
+	// generate fills in generated passwords.  Unless dryRun is set, this waits
+	// until the seeder lock is held, so that seeders starting together do not
+	// each save a password of their own.
+	generate := func(dryRun bool) {
+		if store != nil {
+			err := generatePasswords(ctx, seedConfigs, store, dryRun)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error generating passwords: %v\n", err)
+				exit(1)
+			}
+		}
+		for _, seedConfig := range seedConfigs {
+			if seedConfig.Password == "" {
+				fmt.Fprintf(os.Stderr, "Warning: user %s of database %s has an empty password; use -generate-passwords to generate one\n", seedConfig.Username, seedConfig.Name)
+			}
+		}
+	}
+	// fail reports an error that is not specific to any database, and exits
+	fail := func(code int, format string, err error) {
+		fmt.Fprintf(os.Stderr, format, err)
+		reports.write(newSeedReport(nil, time.Since(start), err))
+		exit(code)
+	}
+
+	sqlDB, err := dialect.open(dsn)
+	if err != nil {
+		fail(1, "Error connecting to database: %s\n", err)
+	}
+	sqlDB.SetMaxOpenConns(parallelism)
+	db := &connection{
+		DB:                sqlDB,
+		dialect:           dialect,
+		dsn:               dsn,
+		alterCharset:      alterCharset,
+		wildcardHosts:     wildcardHosts,
+		templateSizeLimit: templateSizeLimit << 20,
+	}
+
+	err = waitForServer(ctx, db.DB, dialect, retry)
+	if _, ok := err.(*permanentError); ok {
+		fail(exitPermanentFailure, "Error connecting to database, not retrying: %v\n", err)
+	}
+	if err != nil {
+		fail(1, "Error connecting to database: %v\n", err)
+	}
+
+	if plan || command == "check" {
+		generate(true)
+	}
+
+	if plan {
+		// Passwords being rotated are left alone, as when seeding
+		db.rotating, err = listRotations(ctx, db.DB, dialect)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating database %s: %v\n", seedConfig.Name, err)
-			hasError = true
+			fail(1, "Error listing password rotations: %v\n", err)
 		}
+		plans, hasError := planDatabases(ctx, db, dialect.plan, seedConfigs)
+		prunePlans, pruneError := planPrune(ctx, db, dialect, seedConfigs, prune, pruneDatabases)
+		plans = append(plans, prunePlans...)
+		hasError = hasError || pruneError
+		err = writePlan(os.Stdout, plans, planFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing plan: %v\n", err)
+			os.Exit(1)
+		}
+		if hasError {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "check" {
+		drifts, drifted, hasError := checkDrift(ctx, db, seedConfigs)
+		err = writeDrift(os.Stdout, drifts, planFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing drift: %v\n", err)
+			os.Exit(1)
+		}
+		if hasError {
+			os.Exit(1)
+		}
+		if drifted {
+			os.Exit(exitDrift)
+		}
+		return
+	}
+
+	// Only one seeder makes changes at a time, so that several instances
+	// starting together do not race
+	lock, waited, err := acquireLock(ctx, dialect, dsn, lockTimeout)
+	if err != nil {
+		fail(1, "Error taking the seeder lock: %v\n", err)
+	}
+	defer lock.Release()
+	releaseOnSignal(cancel, lock)
+	generate(false)
+
+	err = setupBookkeeping(ctx, db.DB, dialect)
+	if err != nil {
+		fail(1, "Error setting up bookkeeping: %v\n", err)
+	}
+
+	if command == "rotate" {
+		if rotatePasswords(ctx, db, lock, seedConfigs, rotation) {
+			exit(1)
+		}
+		fmt.Printf("Password rotation complete.")
+		return
+	}
+
+	// A seeder that waited for another only seeds the users again if the
+	// other left something undone.  Migrations, data and pruning are always
+	// run, as they only do what is still left to do.
+	usersInPlace := false
+	if waited {
+		usersInPlace = checkOtherSeeder(ctx, db, seedConfigs)
+	}
+
+	db.rotating, err = listRotations(ctx, db.DB, dialect)
+	if err != nil {
+		fail(1, "Error listing password rotations: %v\n", err)
+	}
+
+	outcomes, hasError := seedDatabases(ctx, db, seedConfigs, parallelism, usersInPlace)
+
+	// Only prune once everything listed is in place, so that a failure cannot
+	// leave a user without access.
+	err = nil
+	if !hasError && pruneStale(ctx, db.DB, dialect, seedConfigs, prune, pruneDatabases) {
+		err = errors.New("could not prune databases that are no longer listed")
+		hasError = true
+	}
+
+	if verify && verifyOutcomes(ctx, db, outcomes) {
+		hasError = true
+	}
+
+	if !reports.write(newSeedReport(outcomes, time.Since(start), err)) {
+		hasError = true
 	}
 
 	if hasError {
-		os.Exit(1)
+		exit(1)
 	}
 
 	fmt.Printf("Database seeding complete.")
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationsTable is the table, in each seeded database with migrations, that
+// records which have been applied.
+const migrationsTable = bookkeepingSchema + "_migrations"
+
+// migrationSQL holds the statements for tracking migrations in a database,
+// run as the seeded user.  record takes the version, name, checksum and the
+// time it was applied.
+type migrationSQL struct {
+	setup  string
+	list   string
+	record string
+	// transactional is set if schema changes can be rolled back, so that each
+	// migration can be applied in its own transaction
+	transactional bool
+	// dsn changes the DSN of the seeded user so that a migration may hold
+	// several statements
+	dsn func(dsn string) (string, error)
+}
+
+// migration is a versioned SQL file.
+type migration struct {
+	version  int64
+	name     string
+	sql      string
+	checksum string
+}
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)(_.*)?\.sql$`)
+
+// loadMigrations reads the migrations in a directory, in order of version.
+// Migration files are named after their version, such as 0001_tables.sql;
+// other files are ignored.
+func loadMigrations(dir string) ([]migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := make(map[int64]string)
+	for _, file := range files {
+		match := migrationFilePattern.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", file.Name(), err)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, file.Name())
+		}
+		seen[version] = file.Name()
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		migrations = append(migrations, migration{
+			version:  version,
+			name:     file.Name(),
+			sql:      string(data),
+			checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// applyMigrations applies the migrations of a seed configuration that have not
+// been applied yet, in order, as its user.  Migrations that were applied but
+// have since been edited are reported as errors, before anything is applied.
+// It returns the names of the migrations applied.
+func applyMigrations(ctx context.Context, db *connection, seedConfig SeedConfig) ([]string, error) {
+	m := db.dialect.migrations
+	migrations, err := loadMigrations(seedConfig.Migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	dsn, err := db.dsnAs(seedConfig)
+	if err == nil {
+		dsn, err = m.dsn(dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	userDB, err := db.dialect.open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer userDB.Close()
+	userDB.SetMaxOpenConns(1)
+
+	_, err = userDB.ExecContext(ctx, m.setup)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := listMigrations(ctx, userDB, m)
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range migrations {
+		checksum, ok := applied[migration.version]
+		if ok && checksum != migration.checksum {
+			return nil, fmt.Errorf("migration %s has been edited since it was applied", migration.name)
+		}
+	}
+
+	var names []string
+	for _, migration := range migrations {
+		if _, ok := applied[migration.version]; ok {
+			continue
+		}
+		err = applyMigration(ctx, userDB, m, migration)
+		if err != nil {
+			return names, fmt.Errorf("migration %s: %v", migration.name, err)
+		}
+		names = append(names, migration.name)
+	}
+	return names, nil
+}
+
+// listMigrations returns the checksums of the migrations applied, by version.
+func listMigrations(ctx context.Context, db *sql.DB, m migrationSQL) (map[int64]string, error) {
+	rows, err := db.QueryContext(ctx, m.list)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]string)
+	for rows.Next() {
+		var version int64
+		var checksum string
+		err = rows.Scan(&version, &checksum)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = checksum
+	}
+	return applied, rows.Err()
+}
+
+// applyMigration applies a single migration and records it, in one
+// transaction if the dialect allows.  Otherwise a failure can leave the
+// migration partly applied.
+func applyMigration(ctx context.Context, db *sql.DB, m migrationSQL, migration migration) error {
+	var tx queryer = db
+	if m.transactional {
+		sqlTx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer sqlTx.Rollback()
+		tx = sqlTx
+	}
+
+	_, err := tx.ExecContext(ctx, migration.sql)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, m.record, migration.version, migration.name, migration.checksum, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	if sqlTx, ok := tx.(*sql.Tx); ok {
+		return sqlTx.Commit()
+	}
+	return nil
+}
@@ -0,0 +1,1388 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// openMySQL opens a connection to a MySQL-compatible server.
+func openMySQL(dsn string) (*sql.DB, error) {
+	return sql.Open("mysql", dsn)
+}
+
+// mysqlDSNFor returns the DSN with its database replaced.
+func mysqlDSNFor(dsn, database string) (string, error) {
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	config.DBName = database
+	return config.FormatDSN(), nil
+}
+
+// mysqlDSNAs returns the DSN with its database and credentials replaced.
+func mysqlDSNAs(dsn, database, username, password string) (string, error) {
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	config.DBName = database
+	config.User = username
+	config.Passwd = password
+	return config.FormatDSN(), nil
+}
+
+// mysqlBuildDSN builds a DSN from connection options.  Unless overridden by
+// parameters, the connection uses utf8mb4 and allows cleartext passwords (for
+// servers authenticating through PAM or LDAP), as the BOSH job always has.
+func mysqlBuildDSN(options connectionOptions, password string) (string, error) {
+	config := mysql.NewConfig()
+	config.User = options.username
+	config.Passwd = password
+	config.DBName = options.database
+	config.AllowCleartextPasswords = true
+	if options.socket != "" {
+		config.Net = "unix"
+		config.Addr = options.socket
+	} else {
+		host, port := options.host, options.port
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		if port == "" {
+			port = "3306"
+		}
+		config.Net = "tcp"
+		config.Addr = net.JoinHostPort(host, port)
+	}
+
+	// Parameters are parsed by the driver, so that the ones it knows about
+	// (such as tls) take effect.  The DSN always has parameters already, as
+	// cleartext passwords are allowed.
+	params := []string{"charset=utf8mb4"}
+	for _, param := range options.params {
+		params = append(params, url.QueryEscape(param[0])+"="+url.QueryEscape(param[1]))
+	}
+	config, err := mysql.ParseDSN(config.FormatDSN() + "&" + strings.Join(params, "&"))
+	if err != nil {
+		return "", err
+	}
+	return config.FormatDSN(), nil
+}
+
+// mysqlTLSConfigName is the name the -tls-* configuration is registered with
+// the driver under.
+const mysqlTLSConfigName = "database-seeder"
+
+// mysqlWithTLS registers the TLS configuration with the driver, and returns
+// the DSN changed to use it.
+func mysqlWithTLS(dsn string, options tlsOptions) (string, error) {
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	if config.TLSConfig == "false" {
+		return "", errors.New("the DSN disables TLS with tls=false")
+	}
+
+	tlsConfig, err := options.config()
+	if err != nil {
+		return "", err
+	}
+	err = mysql.RegisterTLSConfig(mysqlTLSConfigName, tlsConfig)
+	if err != nil {
+		return "", err
+	}
+	config.TLSConfig = mysqlTLSConfigName
+	return config.FormatDSN(), nil
+}
+
+// mysqlPermanentError returns whether a connection error will not go away by
+// retrying, such as a failure to authenticate.  Errors while the server is
+// still starting up are transient.
+func mysqlPermanentError(err error) bool {
+	switch err {
+	case mysql.ErrNoTLS, mysql.ErrCleartextPassword, mysql.ErrNativePassword,
+		mysql.ErrOldPassword, mysql.ErrUnknownPlugin, mysql.ErrOldProtocol:
+		return true
+	}
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	switch mysqlErr.Number {
+	case 1044, // ER_DBACCESS_DENIED_ERROR
+		1045, // ER_ACCESS_DENIED_ERROR
+		1049, // ER_BAD_DB_ERROR
+		1129, // ER_HOST_IS_BLOCKED
+		1130, // ER_HOST_NOT_PRIVILEGED
+		1251, // ER_NOT_SUPPORTED_AUTH_MODE
+		1698: // ER_ACCESS_DENIED_NO_PASSWORD_ERROR
+		return true
+	}
+	return false
+}
+
+// mysqlFlavor identifies which MySQL-compatible server we are talking to.
+type mysqlFlavor string
+
+const (
+	flavorMySQL   mysqlFlavor = "MySQL"
+	flavorMariaDB mysqlFlavor = "MariaDB"
+	flavorPercona mysqlFlavor = "Percona"
+)
+
+// mysqlServer describes the server flavor and version, as detected via
+// SELECT VERSION().
+type mysqlServer struct {
+	Flavor  mysqlFlavor
+	Version string
+	Major   int
+	Minor   int
+	Patch   int
+
+	// NoBackslashEscapes is set if the session SQL mode disables backslash
+	// escapes in string literals.
+	NoBackslashEscapes bool
+}
+
+var mysqlVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+// detectMySQLServer determines the flavor and version of the server.
+func detectMySQLServer(ctx context.Context, db queryer) (*mysqlServer, error) {
+	server := &mysqlServer{Flavor: flavorMySQL}
+	err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&server.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	match := mysqlVersionPattern.FindStringSubmatch(server.Version)
+	if match == nil {
+		return nil, fmt.Errorf("could not parse server version %q", server.Version)
+	}
+	server.Major, _ = strconv.Atoi(match[1])
+	server.Minor, _ = strconv.Atoi(match[2])
+	server.Patch, _ = strconv.Atoi(match[3])
+
+	var sqlMode string
+	err = db.QueryRowContext(ctx, "SELECT @@SESSION.sql_mode").Scan(&sqlMode)
+	if err != nil {
+		return nil, err
+	}
+	for _, mode := range strings.Split(sqlMode, ",") {
+		if mode == "NO_BACKSLASH_ESCAPES" {
+			server.NoBackslashEscapes = true
+		}
+	}
+
+	if strings.Contains(server.Version, "MariaDB") {
+		server.Flavor = flavorMariaDB
+		return server, nil
+	}
+
+	// Percona Server and Percona XtraDB Cluster only identify themselves in
+	// the version comment; a failure here just means plain MySQL.
+	var comment string
+	if db.QueryRowContext(ctx, "SELECT @@version_comment").Scan(&comment) == nil {
+		if strings.Contains(comment, "Percona") {
+			server.Flavor = flavorPercona
+		}
+	}
+
+	return server, nil
+}
+
+func (s *mysqlServer) String() string {
+	return fmt.Sprintf("%s %s", s.Flavor, s.Version)
+}
+
+// atLeast returns whether the server version is at least the one given.
+func (s *mysqlServer) atLeast(major, minor, patch int) bool {
+	if s.Major != major {
+		return s.Major > major
+	}
+	if s.Minor != minor {
+		return s.Minor > minor
+	}
+	return s.Patch >= patch
+}
+
+// quoter returns the quoter matching the session SQL mode.
+func (s *mysqlServer) quoter() mysqlQuoter {
+	return mysqlQuoter{noBackslashEscapes: s.NoBackslashEscapes}
+}
+
+// maxUsernameLength returns the maximum length of a user name, in characters.
+func (s *mysqlServer) maxUsernameLength() int {
+	if s.Flavor == flavorMariaDB {
+		if s.atLeast(10, 0, 0) {
+			return 80
+		}
+		return 16
+	}
+	if s.atLeast(5, 7, 8) {
+		return 32
+	}
+	return 16
+}
+
+// maxHostLength returns the maximum length of an account host name, in
+// characters.
+func (s *mysqlServer) maxHostLength() int {
+	if s.Flavor != flavorMariaDB && s.atLeast(8, 0, 17) {
+		return 255
+	}
+	return 60
+}
+
+// supportsAlterUser returns whether the server understands
+// CREATE USER IF NOT EXISTS and ALTER USER ... IDENTIFIED BY.  MySQL 8.0
+// (and Percona Server 8.0) no longer accept GRANT ... IDENTIFIED BY, so
+// those must use the separate statements instead.
+func (s *mysqlServer) supportsAlterUser() bool {
+	if s.Flavor == flavorMariaDB {
+		return s.atLeast(10, 2, 0)
+	}
+	return s.atLeast(5, 7, 6)
+}
+
+// databasePrivileges returns the privileges that GRANT ALL confers at the
+// database level.
+func (s *mysqlServer) databasePrivileges() []string {
+	privileges := []string{
+		"SELECT", "INSERT", "UPDATE", "DELETE", "CREATE", "DROP",
+		"REFERENCES", "INDEX", "ALTER", "CREATE TEMPORARY TABLES",
+		"LOCK TABLES", "EXECUTE", "CREATE VIEW", "SHOW VIEW",
+		"CREATE ROUTINE", "ALTER ROUTINE", "EVENT", "TRIGGER",
+	}
+	if s.Flavor == flavorMariaDB && s.atLeast(10, 3, 4) {
+		privileges = append(privileges, "DELETE HISTORY")
+	}
+	return privileges
+}
+
+// seededPrivileges returns the privileges to grant on the seeded database,
+// according to the profile or explicit privileges of the seed configuration.
+// The owner profile is everything except LOCK TABLES.
+func (s *mysqlServer) seededPrivileges(seedConfig SeedConfig) ([]string, error) {
+	var owner []string
+	for _, privilege := range s.databasePrivileges() {
+		if privilege != "LOCK TABLES" {
+			owner = append(owner, privilege)
+		}
+	}
+	profiles := map[string][]string{
+		profileOwner:     owner,
+		profileReadWrite: {"SELECT", "INSERT", "UPDATE", "DELETE"},
+		profileReadOnly:  {"SELECT"},
+	}
+	return resolvePrivileges(seedConfig, s.databasePrivileges(), profiles)
+}
+
+// mysqlAccountState describes what currently exists on the server for a
+// seed configuration and one of its hosts.
+type mysqlAccountState struct {
+	DatabaseExists bool
+	// Charset and Collation are the defaults of the database
+	Charset    string
+	Collation  string
+	UserExists bool
+	Plugin     string
+	AuthString string
+	// MaxUserConnections, MaxQuestions and MaxUpdates are the resource limits
+	// of the account; 0 for none
+	MaxUserConnections int64
+	MaxQuestions       int64
+	MaxUpdates         int64
+	// Privileges held by the user on the seeded database
+	Privileges map[string]bool
+	// WildcardPrivileges are held on the database name taken as a pattern,
+	// where it has _ or % in it, from grants made before those were escaped
+	WildcardPrivileges map[string]bool
+}
+
+var mysqlGrantPattern = regexp.MustCompile("^GRANT (.+?) ON (`(?:[^`]|``)*`|\\*)\\.\\* TO (.*)$")
+
+// inspectMySQL looks up the database, and the account and grants for one
+// host, of a seed configuration.
+func inspectMySQL(ctx context.Context, db queryer, server *mysqlServer, seedConfig SeedConfig, host string) (*mysqlAccountState, error) {
+	q := server.quoter()
+	state := &mysqlAccountState{Privileges: make(map[string]bool)}
+
+	err := db.QueryRowContext(ctx,
+		"SELECT DEFAULT_CHARACTER_SET_NAME, DEFAULT_COLLATION_NAME FROM INFORMATION_SCHEMA.SCHEMATA WHERE SCHEMA_NAME = ?",
+		seedConfig.Name).Scan(&state.Charset, &state.Collation)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	state.DatabaseExists = err == nil
+
+	authColumn := "Password"
+	if server.Flavor != flavorMariaDB && server.atLeast(5, 7, 6) {
+		authColumn = "authentication_string"
+	}
+	err = db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT plugin, COALESCE(%s, ''), max_user_connections, max_questions, max_updates FROM mysql.user WHERE User = ? AND Host = ?", authColumn),
+		seedConfig.Username, host).Scan(&state.Plugin, &state.AuthString, &state.MaxUserConnections, &state.MaxQuestions, &state.MaxUpdates)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state.UserExists = true
+
+	account := q.Account(seedConfig.Username, host)
+	state.Privileges, err = mysqlGrants(ctx, db, server, account, q.GrantDatabase(seedConfig.Name))
+	if err != nil {
+		return nil, err
+	}
+	if pattern := q.Identifier(seedConfig.Name); pattern != q.GrantDatabase(seedConfig.Name) {
+		state.WildcardPrivileges, err = mysqlGrants(ctx, db, server, account, pattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// charsetMismatch describes how the character set and collation of the
+// database differ from those asked for, if it exists and they do.
+func (state *mysqlAccountState) charsetMismatch(seedConfig SeedConfig) string {
+	if !state.DatabaseExists {
+		return ""
+	}
+	return charsetMismatch(seedConfig, state.Charset, state.Collation, mysqlSameCharset)
+}
+
+// mysqlSameCharset compares character set or collation names.  utf8 is an
+// alias of utf8mb3, which newer servers report instead.
+func mysqlSameCharset(a, b string) bool {
+	normalize := func(name string) string {
+		name = strings.ToLower(name)
+		if name == "utf8" || strings.HasPrefix(name, "utf8_") {
+			name = "utf8mb3" + name[len("utf8"):]
+		}
+		return name
+	}
+	return normalize(a) == normalize(b)
+}
+
+// charsetClause returns the CHARACTER SET and COLLATE options for the
+// database of a seed configuration, if it names them.
+func (q mysqlQuoter) charsetClause(seedConfig SeedConfig) string {
+	var clause string
+	if seedConfig.Charset != "" {
+		clause += " CHARACTER SET " + q.Identifier(seedConfig.Charset)
+	}
+	if seedConfig.Collation != "" {
+		clause += " COLLATE " + q.Identifier(seedConfig.Collation)
+	}
+	return clause
+}
+
+// mysqlUserHosts returns the hosts of every account of a user.
+func mysqlUserHosts(ctx context.Context, db queryer, username string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT Host FROM mysql.user WHERE User = ? ORDER BY Host", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []string
+	for rows.Next() {
+		var host string
+		err = rows.Scan(&host)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, rows.Err()
+}
+
+// mysqlGrants returns the privileges held by an account (as quoted, or
+// CURRENT_USER()) on a database (as quoted, or * for global privileges), as
+// listed by SHOW GRANTS.
+func mysqlGrants(ctx context.Context, db queryer, server *mysqlServer, account, database string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW GRANTS FOR %s", account))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	privileges := make(map[string]bool)
+	for rows.Next() {
+		var grant string
+		err = rows.Scan(&grant)
+		if err != nil {
+			return nil, err
+		}
+		match := mysqlGrantPattern.FindStringSubmatch(grant)
+		if match == nil || match[2] != database {
+			continue
+		}
+		for _, privilege := range strings.Split(match[1], ", ") {
+			switch privilege {
+			case "ALL", "ALL PRIVILEGES":
+				for _, p := range server.databasePrivileges() {
+					privileges[p] = true
+				}
+			case "USAGE":
+			default:
+				privileges[privilege] = true
+			}
+		}
+		if strings.HasSuffix(match[3], " WITH GRANT OPTION") {
+			privileges["GRANT OPTION"] = true
+		}
+	}
+	return privileges, rows.Err()
+}
+
+// mysqlNativePassword returns the mysql_native_password hash of a password.
+func mysqlNativePassword(password string) string {
+	first := sha1.Sum([]byte(password))
+	second := sha1.Sum(first[:])
+	return "*" + strings.ToUpper(hex.EncodeToString(second[:]))
+}
+
+// passwordMatches reports whether the stored credentials match the password.
+// If the hash cannot be checked (because it is salted), known is false.
+func (state *mysqlAccountState) passwordMatches(password string) (matches, known bool) {
+	switch state.Plugin {
+	case "", "mysql_native_password":
+		if password == "" {
+			return state.AuthString == "", true
+		}
+		return state.AuthString == mysqlNativePassword(password), true
+	}
+	return false, false
+}
+
+// limits returns the resource limits the seed configuration sets for the
+// account.
+func (state *mysqlAccountState) limits(seedConfig SeedConfig) []resourceLimit {
+	var limits []resourceLimit
+	for _, limit := range []struct {
+		clause  string
+		wanted  *int64
+		current int64
+	}{
+		{"MAX_USER_CONNECTIONS", seedConfig.Limits.MaxUserConnections, state.MaxUserConnections},
+		{"MAX_QUERIES_PER_HOUR", seedConfig.Limits.MaxQueriesPerHour, state.MaxQuestions},
+		{"MAX_UPDATES_PER_HOUR", seedConfig.Limits.MaxUpdatesPerHour, state.MaxUpdates},
+	} {
+		if limit.wanted != nil {
+			limits = append(limits, resourceLimit{Clause: limit.clause, Wanted: *limit.wanted, Current: limit.current})
+		}
+	}
+	return limits
+}
+
+// mysqlLimitClause returns the WITH clause setting resource limits, if any.
+func mysqlLimitClause(limits []resourceLimit) string {
+	if len(limits) == 0 {
+		return ""
+	}
+	return " WITH" + limitClause(limits)
+}
+
+func mysqlPlanner(ctx context.Context, tx *sql.Tx, seedConfig SeedConfig, rotating bool) ([]planStep, error) {
+	server, err := detectMySQLServer(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateMySQLSeedConfig(server, seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted, err := server.seededPrivileges(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := mysqlHosts(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []planStep{}
+	q := server.quoter()
+
+	for i, host := range hosts {
+		state, err := inspectMySQL(ctx, tx, server, seedConfig, host)
+		if err != nil {
+			return nil, err
+		}
+		account := q.Account(seedConfig.Username, host)
+
+		if i == 0 && !state.DatabaseExists {
+			steps = append(steps, planStep{Action: actionCreateDatabase, Detail: templateDetail(seedConfig)})
+		}
+		if mismatch := state.charsetMismatch(seedConfig); i == 0 && mismatch != "" {
+			steps = append(steps, planStep{Action: actionCharset, Detail: mismatch})
+		}
+
+		if !state.UserExists {
+			steps = append(steps, planStep{Action: actionCreateUser, Detail: account})
+		} else if rotating {
+			steps = append(steps, planStep{Action: actionRetain, Detail: "password of " + account + " while its rotation is in progress"})
+		} else if matches, known := state.passwordMatches(seedConfig.Password); !known {
+			steps = append(steps, planStep{
+				Action: actionChangePassword,
+				Detail: fmt.Sprintf("%s (%s hashes cannot be compared; the password will be reset)", account, state.Plugin),
+			})
+		} else if !matches {
+			steps = append(steps, planStep{Action: actionChangePassword, Detail: account})
+		}
+
+		if changed := changedLimits(state.limits(seedConfig)); len(changed) > 0 {
+			steps = append(steps, planStep{Action: actionSetLimits, Detail: joinLimits(changed) + " on " + account})
+		}
+
+		missing, extra := privilegeChanges(state.Privileges, wanted)
+		if len(missing) > 0 {
+			steps = append(steps, planStep{Action: actionGrant, Detail: strings.Join(missing, ", ") + " to " + account})
+		}
+		if len(extra) > 0 {
+			steps = append(steps, planStep{Action: actionRevoke, Detail: strings.Join(extra, ", ") + " from " + account})
+		}
+		if _, wildcard := privilegeChanges(state.WildcardPrivileges, nil); len(wildcard) > 0 {
+			steps = append(steps, planStep{
+				Action: actionRevoke,
+				Detail: fmt.Sprintf("%s on %s.* (a pattern matching other databases too) from %s", strings.Join(wildcard, ", "), q.Identifier(seedConfig.Name), account),
+			})
+		}
+	}
+
+	stale, err := mysqlStaleAccount(ctx, tx, server, seedConfig, hosts)
+	if err != nil {
+		return nil, err
+	}
+	if stale != "" {
+		steps = append(steps, planStep{Action: actionDropUser, Detail: stale})
+	}
+
+	return steps, nil
+}
+
+// mysqlStaleAccount returns the quoted account of the user for any host, if
+// the hosts are restricted and it still exists from before.
+func mysqlStaleAccount(ctx context.Context, db queryer, server *mysqlServer, seedConfig SeedConfig, hosts []string) (string, error) {
+	if containsHost(hosts, anyHost) {
+		return "", nil
+	}
+	state, err := inspectMySQL(ctx, db, server, seedConfig, anyHost)
+	if err != nil || !state.UserExists {
+		return "", err
+	}
+	return server.quoter().Account(seedConfig.Username, anyHost), nil
+}
+
+func mysqlCreator(ctx context.Context, db *connection, seedConfig SeedConfig) (changes seedChanges, err error) {
+
+	// exec runs a statement built from the given arguments, which must
+	// already have been quoted as appropriate.
+	exec := func(stmt string, args ...interface{}) (sql.Result, error) {
+		return db.ExecContext(ctx, fmt.Sprintf(stmt, args...))
+	}
+
+	server, err := detectMySQLServer(ctx, db)
+	if err != nil {
+		return changes, err
+	}
+
+	err = validateMySQLSeedConfig(server, seedConfig)
+	if err != nil {
+		return changes, err
+	}
+
+	wanted, err := server.seededPrivileges(seedConfig)
+	if err != nil {
+		return changes, err
+	}
+
+	hosts, err := mysqlHosts(seedConfig)
+	if err != nil {
+		return changes, err
+	}
+
+	states := make([]*mysqlAccountState, len(hosts))
+	for i, host := range hosts {
+		states[i], err = inspectMySQL(ctx, db, server, seedConfig, host)
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	stale, err := mysqlStaleAccount(ctx, db, server, seedConfig, hosts)
+	if err != nil {
+		return changes, err
+	}
+
+	changes = seedChanges{Database: statusUnchanged, User: statusCreated}
+	if !states[0].DatabaseExists {
+		changes.Database = statusCreated
+	}
+	mismatch := states[0].charsetMismatch(seedConfig)
+	if mismatch != "" && db.alterCharset {
+		changes.Database = statusChanged
+	} else if mismatch != "" {
+		changes.Warnings = append(changes.Warnings, mismatch+"; use -alter-charset to change it")
+	}
+	existed, changed := stale != "", stale != ""
+	for _, state := range states {
+		if !state.UserExists {
+			changed = true
+			continue
+		}
+		existed = true
+		missing, extra := privilegeChanges(state.Privileges, wanted)
+		if matches, known := state.passwordMatches(seedConfig.Password); (known && !matches && !db.isRotating(seedConfig.Username)) || len(missing) > 0 || len(extra) > 0 {
+			changed = true
+		}
+		if len(changedLimits(state.limits(seedConfig))) > 0 || len(state.WildcardPrivileges) > 0 {
+			changed = true
+		}
+	}
+	if existed {
+		changes.User = statusUnchanged
+		if changed {
+			changes.User = statusChanged
+		}
+	}
+
+	q := server.quoter()
+	database := q.Identifier(seedConfig.Name)
+	grantDatabase := q.GrantDatabase(seedConfig.Name)
+	password := q.Literal(seedConfig.Password)
+	privileges := strings.Join(wanted, ", ")
+	// The password of an existing account is left alone while it is being
+	// rotated
+	rotating := db.isRotating(seedConfig.Username)
+
+	// Create the database.  Altering it only changes the defaults for tables
+	// created later.
+	_, err = exec("CREATE DATABASE IF NOT EXISTS %s%s", database, q.charsetClause(seedConfig))
+	if err != nil {
+		return changes, err
+	}
+	if seedConfig.Template != "" && changes.Database == statusCreated {
+		var warnings []string
+		warnings, err = mysqlCloneDatabase(ctx, db, server, seedConfig)
+		if err != nil {
+			// Nothing but the copy is in the new database yet, and it would
+			// not be copied again
+			exec("DROP DATABASE %s", database)
+			return changes, fmt.Errorf("copying template database %s: %v", seedConfig.Template, err)
+		}
+		changes.Warnings = append(changes.Warnings, warnings...)
+	}
+	if mismatch != "" && db.alterCharset {
+		_, err = exec("ALTER DATABASE %s%s", database, q.charsetClause(seedConfig))
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	for i, host := range hosts {
+		account := q.Account(seedConfig.Username, host)
+		limits := mysqlLimitClause(states[i].limits(seedConfig))
+
+		if server.supportsAlterUser() {
+			// Create the user, and update the password and limits in case it
+			// already existed
+			_, err = exec("CREATE USER IF NOT EXISTS %s IDENTIFIED BY %s", account, password)
+			if err != nil {
+				return changes, err
+			}
+
+			if !rotating {
+				_, err = exec("ALTER USER %s IDENTIFIED BY %s%s", account, password, limits)
+			} else if limits != "" {
+				_, err = exec("ALTER USER %s%s", account, limits)
+			}
+			if err != nil {
+				return changes, err
+			}
+
+			_, err = exec("GRANT %s ON %s.* TO %s", privileges, grantDatabase, account)
+			if err != nil {
+				return changes, err
+			}
+		} else {
+			// Grant privileges (implicitly creates or updates credentials as needed)
+			_, err = exec("GRANT %s ON %s.* TO %s IDENTIFIED BY %s%s", privileges, grantDatabase, account, password, limits)
+			if err != nil {
+				return changes, err
+			}
+		}
+
+		// Revoke anything beyond what was asked for, including LOCK TABLES for
+		// the owner profile
+		_, extra := privilegeChanges(states[i].Privileges, wanted)
+		if len(extra) > 0 {
+			_, err = exec("REVOKE %s ON %s.* FROM %s", strings.Join(extra, ", "), grantDatabase, account)
+			if err != nil {
+				return changes, err
+			}
+		}
+
+		// Grants made before wildcards were escaped cover other databases too
+		_, wildcard := privilegeChanges(states[i].WildcardPrivileges, nil)
+		if len(wildcard) > 0 {
+			_, err = exec("REVOKE %s ON %s.* FROM %s", strings.Join(wildcard, ", "), database, account)
+			if err != nil {
+				return changes, err
+			}
+		}
+	}
+
+	// Only drop the account for any host once the restricted ones are in
+	// place.  Another database of the same user may have dropped it already.
+	if stale != "" {
+		_, err = exec("DROP USER %s", stale)
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1396 { // ER_CANNOT_USER
+			err = nil
+		}
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, nil
+}
+
+// mysqlVerifier logs in as the seeded user, and checks that the server
+// authenticates it as one of the seeded accounts (rather than one for a more
+// specific host), that it holds exactly the privileges it should, and that LOCK TABLES
+// is denied unless it was asked for.
+func mysqlVerifier(ctx context.Context, db *connection, seedConfig SeedConfig) error {
+	server, err := detectMySQLServer(ctx, db)
+	if err != nil {
+		return err
+	}
+	wanted, err := server.seededPrivileges(seedConfig)
+	if err != nil {
+		return err
+	}
+	hosts, err := mysqlHosts(seedConfig)
+	if err != nil {
+		return err
+	}
+
+	userDB, err := db.openAs(seedConfig)
+	if err != nil {
+		return err
+	}
+	defer userDB.Close()
+
+	var account string
+	err = userDB.QueryRowContext(ctx, "SELECT CURRENT_USER()").Scan(&account)
+	if err != nil {
+		return fmt.Errorf("could not log in: %v", err)
+	}
+	seeded := false
+	for _, host := range hosts {
+		seeded = seeded || account == seedConfig.Username+"@"+host
+	}
+	if !seeded {
+		return fmt.Errorf("logged in as %s, which takes precedence over the seeded accounts", account)
+	}
+
+	held, err := mysqlGrants(ctx, userDB, server, "CURRENT_USER()", server.quoter().GrantDatabase(seedConfig.Name))
+	if err != nil {
+		return err
+	}
+	missing, extra := privilegeChanges(held, wanted)
+	if len(missing) > 0 {
+		return fmt.Errorf("missing privileges %s", strings.Join(missing, ", "))
+	}
+	if len(extra) > 0 {
+		return fmt.Errorf("unexpected privileges %s", strings.Join(extra, ", "))
+	}
+
+	if held["LOCK TABLES"] {
+		return nil
+	}
+	// The privilege is checked before the table is looked for, so locking a
+	// table that does not exist is enough to tell
+	_, err = userDB.ExecContext(ctx, "LOCK TABLES `"+bookkeepingSchema+"_verify` READ")
+	if err == nil {
+		userDB.ExecContext(ctx, "UNLOCK TABLES")
+		return errors.New("LOCK TABLES is not denied")
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		switch mysqlErr.Number {
+		case 1044: // ER_DBACCESS_DENIED_ERROR
+			return nil
+		case 1146: // ER_NO_SUCH_TABLE
+			return errors.New("LOCK TABLES is not denied")
+		}
+	}
+	return err
+}
+
+// mysqlChecker compares the database, accounts and grants of a seed
+// configuration with what mysqlCreator would produce.  Global privileges, and
+// other accounts of the same user on wildcard hosts, count as drift too.
+func mysqlChecker(ctx context.Context, db *connection, seedConfig SeedConfig) ([]driftFinding, error) {
+	server, err := detectMySQLServer(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	wanted, err := server.seededPrivileges(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := mysqlHosts(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	q := server.quoter()
+	database := q.Identifier(seedConfig.Name)
+	findings := []driftFinding{}
+	for i, host := range hosts {
+		state, err := inspectMySQL(ctx, db, server, seedConfig, host)
+		if err != nil {
+			return nil, err
+		}
+		account := q.Account(seedConfig.Username, host)
+		if i == 0 && !state.DatabaseExists {
+			findings = append(findings, driftFinding{Kind: driftMissingDatabase, Detail: seedConfig.Name})
+		}
+		if mismatch := state.charsetMismatch(seedConfig); i == 0 && mismatch != "" {
+			findings = append(findings, driftFinding{Kind: driftCharset, Detail: mismatch})
+		}
+		if !state.UserExists {
+			findings = append(findings, driftFinding{Kind: driftMissingUser, Detail: account})
+			continue
+		}
+
+		findings = append(findings, limitDrift(state.limits(seedConfig), account)...)
+
+		missing, extra := privilegeChanges(state.Privileges, wanted)
+		for _, privilege := range missing {
+			findings = append(findings, driftFinding{Kind: driftMissingPrivilege, Detail: privilege + " on " + database + " to " + account})
+		}
+		for _, privilege := range extra {
+			findings = append(findings, driftFinding{Kind: driftExtraPrivilege, Detail: privilege + " on " + database + " to " + account})
+		}
+		_, wildcard := privilegeChanges(state.WildcardPrivileges, nil)
+		for _, privilege := range wildcard {
+			findings = append(findings, driftFinding{Kind: driftExtraPrivilege, Detail: privilege + " on " + database + " as a pattern matching other databases, to " + account})
+		}
+
+		global, err := mysqlGrants(ctx, db, server, account, "*")
+		if err != nil {
+			return nil, err
+		}
+		_, extra = privilegeChanges(global, nil)
+		for _, privilege := range extra {
+			findings = append(findings, driftFinding{Kind: driftExtraPrivilege, Detail: privilege + " on *.* to " + account})
+		}
+	}
+
+	// Accounts for other hosts may take precedence over the seeded ones
+	userHosts, err := mysqlUserHosts(ctx, db, seedConfig.Username)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range userHosts {
+		if !strings.ContainsAny(host, "%_") {
+			continue
+		}
+		if !containsHost(hosts, host) {
+			findings = append(findings, driftFinding{Kind: driftWildcardHost, Detail: q.Account(seedConfig.Username, host)})
+		} else if db.wildcardHosts {
+			findings = append(findings, driftFinding{Kind: driftWildcardHost, Detail: q.Account(seedConfig.Username, host) + " (seeded; restrict it with hosts)"})
+		}
+	}
+	return findings, nil
+}
+
+var mysqlBookkeeping = bookkeepingSQL{
+	setup: []string{
+		"CREATE DATABASE IF NOT EXISTS `" + bookkeepingSchema + "`",
+		"CREATE TABLE IF NOT EXISTS `" + bookkeepingSchema + "`.`managed_databases` (" +
+			"`name` VARCHAR(64) NOT NULL, " +
+			"`username` VARCHAR(80) NOT NULL, " +
+			"PRIMARY KEY (`name`, `username`)" +
+			") CHARACTER SET utf8mb4 COLLATE utf8mb4_bin",
+		"CREATE TABLE IF NOT EXISTS `" + bookkeepingSchema + "`.`rotations` (" +
+			"`username` VARCHAR(80) NOT NULL, " +
+			"`started` BIGINT NOT NULL, " +
+			"PRIMARY KEY (`username`)" +
+			") CHARACTER SET utf8mb4 COLLATE utf8mb4_bin",
+	},
+	record: "INSERT IGNORE INTO `" + bookkeepingSchema + "`.`managed_databases` (`name`, `username`) VALUES (?, ?)",
+	list:   "SELECT `name`, `username` FROM `" + bookkeepingSchema + "`.`managed_databases` ORDER BY `name`, `username`",
+	forget: "DELETE FROM `" + bookkeepingSchema + "`.`managed_databases` WHERE `name` = ? AND `username` = ?",
+
+	startRotation:  "INSERT IGNORE INTO `" + bookkeepingSchema + "`.`rotations` (`username`, `started`) VALUES (?, ?)",
+	listRotations:  "SELECT `username`, `started` FROM `" + bookkeepingSchema + "`.`rotations`",
+	forgetRotation: "DELETE FROM `" + bookkeepingSchema + "`.`rotations` WHERE `username` = ?",
+}
+
+var mysqlMigrations = migrationSQL{
+	setup: "CREATE TABLE IF NOT EXISTS `" + migrationsTable + "` (" +
+		"`version` BIGINT NOT NULL, " +
+		"`name` VARCHAR(255) NOT NULL, " +
+		"`checksum` CHAR(64) NOT NULL, " +
+		"`applied` BIGINT NOT NULL, " +
+		"PRIMARY KEY (`version`)" +
+		") CHARACTER SET utf8mb4 COLLATE utf8mb4_bin",
+	list:   "SELECT `version`, `checksum` FROM `" + migrationsTable + "`",
+	record: "INSERT INTO `" + migrationsTable + "` (`version`, `name`, `checksum`, `applied`) VALUES (?, ?, ?, ?)",
+	// Schema changes commit implicitly
+	transactional: false,
+	dsn: func(dsn string) (string, error) {
+		config, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", err
+		}
+		config.MultiStatements = true
+		return config.FormatDSN(), nil
+	},
+}
+
+// mysqlLock is a named lock, which GET_LOCK waits for in whole seconds.
+var mysqlLock = advisoryLock{
+	acquire: func(ctx context.Context, conn *sql.Conn, timeout time.Duration) (bool, error) {
+		seconds := int64((timeout + time.Second - 1) / time.Second)
+		var acquired sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, seconds).Scan(&acquired)
+		if err != nil {
+			return false, err
+		}
+		return acquired.Valid && acquired.Int64 == 1, nil
+	},
+	release: "SELECT RELEASE_LOCK('" + lockName + "')",
+}
+
+// mysqlCloneDatabase copies the tables of the template database of a seed
+// configuration, with their rows, into its newly created database.  Tables are
+// created from SHOW CREATE TABLE on a connection to the new database, with
+// foreign key checks off, so that references between them carry over.  Views,
+// routines, triggers and events are not copied; warnings say which were left
+// out.
+func mysqlCloneDatabase(ctx context.Context, db *connection, server *mysqlServer, seedConfig SeedConfig) ([]string, error) {
+	q := server.quoter()
+	template := q.Identifier(seedConfig.Template)
+
+	var exists bool
+	var size int64
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM INFORMATION_SCHEMA.SCHEMATA WHERE SCHEMA_NAME = ?),
+			COALESCE(SUM(DATA_LENGTH + INDEX_LENGTH), 0)
+		FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = ?`,
+		seedConfig.Template, seedConfig.Template).Scan(&exists, &size)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("it does not exist")
+	}
+	if db.templateSizeLimit > 0 && size > db.templateSizeLimit {
+		return nil, fmt.Errorf("it is %d MiB, more than -template-size-limit", size>>20)
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT TABLE_NAME, TABLE_TYPE FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME",
+		seedConfig.Template)
+	if err != nil {
+		return nil, err
+	}
+	var tables, views []string
+	for rows.Next() {
+		var table, tableType string
+		err = rows.Scan(&table, &tableType)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if tableType == "BASE TABLE" {
+			tables = append(tables, table)
+		} else {
+			views = append(views, table)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	if len(views) > 0 {
+		warnings = append(warnings, fmt.Sprintf("views %s of template database %s were not copied", strings.Join(views, ", "), seedConfig.Template))
+	}
+	for _, kind := range []struct {
+		name  string
+		query string
+	}{
+		{"routines", "SELECT ROUTINE_NAME FROM INFORMATION_SCHEMA.ROUTINES WHERE ROUTINE_SCHEMA = ? ORDER BY ROUTINE_NAME"},
+		{"triggers", "SELECT TRIGGER_NAME FROM INFORMATION_SCHEMA.TRIGGERS WHERE TRIGGER_SCHEMA = ? ORDER BY TRIGGER_NAME"},
+		{"events", "SELECT EVENT_NAME FROM INFORMATION_SCHEMA.EVENTS WHERE EVENT_SCHEMA = ? ORDER BY EVENT_NAME"},
+	} {
+		names, err := mysqlNames(ctx, db, kind.query, seedConfig.Template)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s %s of template database %s were not copied", kind.name, strings.Join(names, ", "), seedConfig.Template))
+		}
+	}
+
+	target, err := db.openDatabase(seedConfig.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+	conn, err := target.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "SET SESSION FOREIGN_KEY_CHECKS = 0")
+	if err != nil {
+		return nil, err
+	}
+
+	for i, table := range tables {
+		fmt.Printf("Copying table %s of database %s (%d of %d)...\n", table, seedConfig.Template, i+1, len(tables))
+		var name, create string
+		err = conn.QueryRowContext(ctx, fmt.Sprintf("SHOW CREATE TABLE %s.%s", template, q.Identifier(table))).Scan(&name, &create)
+		if err != nil {
+			return nil, err
+		}
+		_, err = conn.ExecContext(ctx, create)
+		if err != nil {
+			return nil, err
+		}
+
+		// Generated columns cannot be inserted into
+		columns, err := mysqlStoredColumns(ctx, conn, seedConfig.Template, table)
+		if err != nil {
+			return nil, err
+		}
+		for j, column := range columns {
+			columns[j] = q.Identifier(column)
+		}
+		list := strings.Join(columns, ", ")
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s.%s",
+			q.Identifier(table), list, list, template, q.Identifier(table)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return warnings, nil
+}
+
+// mysqlNames returns the names a query for a single column returns.
+func mysqlNames(ctx context.Context, db queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// mysqlStoredColumns returns the columns of a table that are not generated,
+// in order.
+func mysqlStoredColumns(ctx context.Context, db queryer, database, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT COLUMN_NAME, EXTRA FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+		ORDER BY ORDINAL_POSITION`,
+		database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column, extra string
+		err = rows.Scan(&column, &extra)
+		if err != nil {
+			return nil, err
+		}
+		if !mysqlGeneratedColumn(extra) {
+			columns = append(columns, column)
+		}
+	}
+	return columns, rows.Err()
+}
+
+// mysqlGeneratedColumn returns whether a column is generated, from its EXTRA
+// in INFORMATION_SCHEMA.COLUMNS: VIRTUAL GENERATED or STORED GENERATED (on
+// MariaDB, also PERSISTENT GENERATED).  Columns with an expression default,
+// which MySQL 8.0.13 and later marks DEFAULT_GENERATED, are not generated, and
+// their values must be copied.
+func mysqlGeneratedColumn(extra string) bool {
+	for _, word := range strings.Fields(strings.ToUpper(extra)) {
+		if word == "GENERATED" {
+			return true
+		}
+	}
+	return false
+}
+
+// mysqlReaders counts the readers registered with the driver, to name them
+// apart.
+var mysqlReaders int64
+
+// mysqlLoader loads data with LOAD DATA LOCAL INFILE, which the server must
+// allow (local_infile).  Rows are streamed to the server through a reader
+// registered with the driver, rather than a file name, as parsed by the data
+// file reader and written out again in the format LOAD DATA reads by default.
+var mysqlLoader = dataLoader{
+	empty: func(ctx context.Context, db *sql.DB, table string) (bool, error) {
+		var one int
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", mysqlQuoter{}.Identifier(table))).Scan(&one)
+		if err == sql.ErrNoRows {
+			return true, nil
+		}
+		return false, err
+	},
+	load: func(ctx context.Context, db *sql.DB, load dataLoad) (int64, error) {
+		server, err := detectMySQLServer(ctx, db)
+		if err != nil {
+			return 0, err
+		}
+		q := server.quoter()
+
+		rows := mysqlDataStream(load.rows)
+		defer rows.Close()
+		name := fmt.Sprintf("database-seeder-%d", atomic.AddInt64(&mysqlReaders, 1))
+		mysql.RegisterReaderHandler(name, func() io.Reader { return rows })
+		defer mysql.DeregisterReaderHandler(name)
+
+		stmt := fmt.Sprintf("LOAD DATA LOCAL INFILE %s INTO TABLE %s CHARACTER SET utf8mb4"+
+			" FIELDS TERMINATED BY %s ESCAPED BY %s LINES TERMINATED BY %s",
+			q.Literal("Reader::"+name), q.Identifier(load.table), q.Literal("\t"), q.Literal(`\`), q.Literal("\n"))
+		if len(load.columns) > 0 {
+			var columns []string
+			for _, column := range load.columns {
+				columns = append(columns, q.Identifier(column))
+			}
+			stmt += " (" + strings.Join(columns, ", ") + ")"
+		}
+
+		result, err := db.ExecContext(ctx, stmt)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	},
+}
+
+// mysqlDataEscaper escapes a field for LOAD DATA.
+var mysqlDataEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+// mysqlDataStream writes rows out as LOAD DATA reads them by default: fields
+// separated by tabs and escaped with backslashes, \N for NULL, and lines
+// ending in newlines.  Closing the stream stops the writing.
+func mysqlDataStream(rows dataReader) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		buffered := bufio.NewWriter(w)
+		var err error
+		for {
+			var row []sql.NullString
+			row, err = rows.Read()
+			if err != nil {
+				break
+			}
+			for i, field := range row {
+				if i > 0 {
+					buffered.WriteByte('\t')
+				}
+				if field.Valid {
+					buffered.WriteString(mysqlDataEscaper.Replace(field.String))
+				} else {
+					buffered.WriteString(`\N`)
+				}
+			}
+			// Once the stream is closed, writes fail
+			if err = buffered.WriteByte('\n'); err != nil {
+				break
+			}
+		}
+		if err == io.EOF {
+			err = buffered.Flush()
+		}
+		w.CloseWithError(err)
+	}()
+	return r
+}
+
+// mysqlRotator rotates passwords using the dual passwords of MySQL 8.0.14 and
+// later: the current password is retained as a secondary one until the
+// rotation finishes.
+var mysqlRotator = passwordRotator{
+	begin: func(ctx context.Context, db *sql.DB, seedConfig SeedConfig) error {
+		server, err := mysqlRotationServer(ctx, db)
+		if err != nil {
+			return err
+		}
+		hosts, err := mysqlHosts(seedConfig)
+		if err != nil {
+			return err
+		}
+		q := server.quoter()
+
+		for _, host := range hosts {
+			account := q.Account(seedConfig.Username, host)
+
+			// Retaining again would replace the old password with the new
+			// one, so check whether this already happened in an earlier run
+			var retained bool
+			err = db.QueryRowContext(ctx,
+				"SELECT COALESCE(JSON_CONTAINS_PATH(User_attributes, 'one', '$.additional_password'), 0) FROM mysql.user WHERE User = ? AND Host = ?",
+				seedConfig.Username, host).Scan(&retained)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("user %s does not exist; seed it first", account)
+			}
+			if err != nil {
+				return err
+			}
+			if retained {
+				continue
+			}
+
+			_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER USER %s IDENTIFIED BY %s RETAIN CURRENT PASSWORD",
+				account, q.Literal(seedConfig.Password)))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	finish: func(ctx context.Context, db *sql.DB, seedConfig SeedConfig, options rotateOptions) error {
+		server, err := mysqlRotationServer(ctx, db)
+		if err != nil {
+			return err
+		}
+		hosts, err := mysqlHosts(seedConfig)
+		if err != nil {
+			return err
+		}
+		for _, host := range hosts {
+			_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER USER %s DISCARD OLD PASSWORD", server.quoter().Account(seedConfig.Username, host)))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	login: func(username string) string { return username },
+}
+
+// mysqlRotationServer detects the server, and checks that it supports dual
+// passwords.
+func mysqlRotationServer(ctx context.Context, db queryer) (*mysqlServer, error) {
+	server, err := detectMySQLServer(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if server.Flavor == flavorMariaDB || !server.atLeast(8, 0, 14) {
+		return nil, fmt.Errorf("password rotation needs MySQL 8.0.14 or later, not %s", server)
+	}
+	return server, nil
+}
+
+func mysqlPruner(ctx context.Context, db queryer, m managedDatabase, options pruneOptions) ([]pruneStatement, error) {
+	server, err := detectMySQLServer(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	seedConfig := SeedConfig{Name: m.Name, Username: m.Username}
+	state, err := inspectMySQL(ctx, db, server, seedConfig, anyHost)
+	if err != nil {
+		return nil, err
+	}
+	databaseExists := state.DatabaseExists
+
+	// The hosts the user was seeded for are not recorded, so every account of
+	// the user is pruned
+	var hosts []string
+	if m.Username != "" {
+		hosts, err = mysqlUserHosts(ctx, db, m.Username)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var statements []pruneStatement
+	q := server.quoter()
+	database := q.Identifier(m.Name)
+
+	for _, host := range hosts {
+		state, err = inspectMySQL(ctx, db, server, seedConfig, host)
+		if err != nil {
+			return nil, err
+		}
+		account := q.Account(m.Username, host)
+		if options.dropUser {
+			statements = append(statements, pruneStatement{
+				step: planStep{Action: actionDropUser, Detail: account},
+				stmt: fmt.Sprintf("DROP USER %s", account),
+			})
+		} else {
+			// Grants outlive the database, so revoke them even when dropping it
+			if len(state.Privileges) > 0 {
+				statements = append(statements, pruneStatement{
+					step: planStep{Action: actionRevoke, Detail: "ALL PRIVILEGES on " + database + " from " + account},
+					stmt: fmt.Sprintf("REVOKE ALL PRIVILEGES ON %s.* FROM %s", q.GrantDatabase(m.Name), account),
+				})
+			}
+			if len(state.WildcardPrivileges) > 0 {
+				statements = append(statements, pruneStatement{
+					step: planStep{Action: actionRevoke, Detail: "ALL PRIVILEGES on " + database + " as a pattern from " + account},
+					stmt: fmt.Sprintf("REVOKE ALL PRIVILEGES ON %s.* FROM %s", database, account),
+				})
+			}
+		}
+	}
+
+	if options.dropDatabase && databaseExists {
+		statements = append(statements, pruneStatement{
+			step: planStep{Action: actionDropDatabase, Detail: m.Name},
+			stmt: fmt.Sprintf("DROP DATABASE %s", database),
+		})
+	}
+
+	return statements, nil
+}
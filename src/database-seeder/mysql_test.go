@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestMySQLGeneratedColumn(t *testing.T) {
+	for extra, want := range map[string]bool{
+		"":               false,
+		"auto_increment": false,
+		// created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, on MySQL 8.0.13+
+		"DEFAULT_GENERATED":                             false,
+		"DEFAULT_GENERATED on update CURRENT_TIMESTAMP": false,
+		"on update CURRENT_TIMESTAMP":                   false,
+		"VIRTUAL GENERATED":                             true,
+		"STORED GENERATED":                              true,
+		"PERSISTENT GENERATED":                          true,
+		"VIRTUAL GENERATED INVISIBLE":                   true,
+	} {
+		if got := mysqlGeneratedColumn(extra); got != want {
+			t.Errorf("mysqlGeneratedColumn(%q) = %v, want %v", extra, got, want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// generatedPasswordLength is the length of generated passwords.  They are
+// alphanumeric, so that consumers never need to quote them; 32 characters give
+// about 190 bits.
+const generatedPasswordLength = 32
+
+const passwordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// passwordStore persists generated passwords, by user name, so that consumers
+// can read them and reruns reuse them.
+type passwordStore interface {
+	// Load returns the stored passwords; a store that does not exist yet is
+	// empty.
+	Load(ctx context.Context) (map[string]string, error)
+	// Save adds the given passwords to the store, keeping any others.
+	Save(ctx context.Context, passwords map[string]string) error
+}
+
+// newPasswordStore returns the store named by location: either
+// "file:/path/to/credentials.json" or "k8s-secret:namespace/name".
+func newPasswordStore(location string, kubernetes *kubernetesClient) (passwordStore, error) {
+	switch {
+	case strings.HasPrefix(location, "file:"):
+		return &filePasswordStore{path: strings.TrimPrefix(location, "file:")}, nil
+	case strings.HasPrefix(location, "k8s-secret:"):
+		namespace, name := "", strings.TrimPrefix(location, "k8s-secret:")
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			namespace, name = name[:i], name[i+1:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("password store %q has no secret name", location)
+		}
+		return &kubernetesPasswordStore{client: kubernetes, namespace: namespace, name: name}, nil
+	}
+	return nil, fmt.Errorf("unknown password store %q; use file:PATH or k8s-secret:NAMESPACE/NAME", location)
+}
+
+// generatePasswords fills in the password of each seed configuration that has
+// none, reusing the password stored for the user if there is one, and
+// otherwise generating a new one.  New passwords are saved before returning,
+// and so before any user is given them, unless dryRun is set.
+func generatePasswords(ctx context.Context, seedConfigs []SeedConfig, store passwordStore, dryRun bool) error {
+	var stored map[string]string
+	generated := make(map[string]string)
+
+	for i, seedConfig := range seedConfigs {
+		if seedConfig.Password != "" {
+			continue
+		}
+		if stored == nil {
+			var err error
+			stored, err = store.Load(ctx)
+			if err != nil {
+				return fmt.Errorf("could not load stored passwords: %v", err)
+			}
+		}
+
+		password, ok := stored[seedConfig.Username]
+		if !ok {
+			password, ok = generated[seedConfig.Username]
+		}
+		if !ok {
+			if !secretKeyPattern.MatchString(seedConfig.Username) {
+				return &seedConfigError{Field: "Username", Value: seedConfig.Username, Reason: "cannot be used as a key for a generated password"}
+			}
+			var err error
+			password, err = randomPassword()
+			if err != nil {
+				return err
+			}
+			generated[seedConfig.Username] = password
+		}
+		seedConfigs[i].Password = password
+	}
+
+	if len(generated) == 0 || dryRun {
+		return nil
+	}
+	err := store.Save(ctx, generated)
+	if err != nil {
+		return fmt.Errorf("could not save generated passwords: %v", err)
+	}
+	return nil
+}
+
+// randomPassword returns a new random password.
+func randomPassword() (string, error) {
+	password := make([]byte, generatedPasswordLength)
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// secretKeyPattern matches the keys allowed in a Kubernetes secret.
+var secretKeyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// filePasswordStore keeps passwords in a JSON object in a local file, which
+// is only readable by its owner.
+type filePasswordStore struct {
+	path string
+}
+
+func (s *filePasswordStore) Load(ctx context.Context) (map[string]string, error) {
+	passwords := make(map[string]string)
+	data, err := readSecretFile(s.path)
+	if os.IsNotExist(err) {
+		return passwords, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &passwords)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s.path, err)
+	}
+	return passwords, nil
+}
+
+func (s *filePasswordStore) Save(ctx context.Context, passwords map[string]string) error {
+	all, err := s.Load(ctx)
+	if err != nil {
+		return err
+	}
+	for username, password := range passwords {
+		all[username] = password
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the file atomically, so that it is never left half written
+	temp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(append(data, '\n'))
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.path)
+}
+
+// kubernetesPasswordStore keeps passwords in a Kubernetes secret, one key per
+// user, creating the secret if needed.
+type kubernetesPasswordStore struct {
+	client    *kubernetesClient
+	namespace string
+	name      string
+}
+
+func (s *kubernetesPasswordStore) Load(ctx context.Context) (map[string]string, error) {
+	err := s.setNamespace()
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.client.getSecret(ctx, s.namespace, s.name)
+	if isNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	passwords := make(map[string]string)
+	for key, value := range data {
+		passwords[key] = string(value)
+	}
+	return passwords, nil
+}
+
+func (s *kubernetesPasswordStore) Save(ctx context.Context, passwords map[string]string) error {
+	err := s.setNamespace()
+	if err != nil {
+		return err
+	}
+
+	// Merge into the existing secret, which leaves the other keys alone
+	patch := map[string]interface{}{"stringData": passwords}
+	err = s.client.do(ctx, http.MethodPatch, secretPath(s.namespace, s.name), patch, nil)
+	if !isNotFound(err) {
+		return err
+	}
+
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata": map[string]interface{}{
+			"name":      s.name,
+			"namespace": s.namespace,
+			"labels":    map[string]string{"app.kubernetes.io/managed-by": "database-seeder"},
+		},
+		"stringData": passwords,
+	}
+	return s.client.do(ctx, http.MethodPost, secretsPath(s.namespace), secret, nil)
+}
+
+// setNamespace defaults the namespace to the pod's own.
+func (s *kubernetesPasswordStore) setNamespace() error {
+	if s.namespace != "" {
+		return nil
+	}
+	namespace, err := s.client.namespace()
+	s.namespace = namespace
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Actions that may appear in a plan
+const (
+	actionCreateDatabase = "create-database"
+	actionCreateUser     = "create-user"
+	actionChangePassword = "change-password"
+	actionGrant          = "grant"
+	actionRevoke         = "revoke"
+	actionDropUser       = "drop-user"
+	actionDropDatabase   = "drop-database"
+	actionRetain         = "retain"
+	actionCharset        = "charset-mismatch"
+	actionSetLimits      = "set-limits"
+)
+
+// templateDetail describes the database to create for a seed configuration,
+// and its template, if any.
+func templateDetail(seedConfig SeedConfig) string {
+	if seedConfig.Template == "" {
+		return seedConfig.Name
+	}
+	return seedConfig.Name + " from template " + seedConfig.Template
+}
+
+// planStep is a single change the seeder would make.
+type planStep struct {
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// databasePlan lists the changes the seeder would make for one SeedConfig.
+type databasePlan struct {
+	Database string     `json:"database"`
+	Username string     `json:"username"`
+	Steps    []planStep `json:"steps"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// dbPlanner inspects the server (via a read-only transaction) and returns the
+// steps needed to seed the given configuration, without changing anything.
+// rotating is set if the password of its user is being rotated, so that
+// seeding leaves it alone.
+type dbPlanner func(ctx context.Context, tx *sql.Tx, seedConfig SeedConfig, rotating bool) ([]planStep, error)
+
+// planDatabases builds the plan for each seed configuration.  Each inspection
+// is done in its own read-only transaction, which is always rolled back.
+func planDatabases(ctx context.Context, db *connection, planner dbPlanner, seedConfigs []SeedConfig) ([]databasePlan, bool) {
+	var plans []databasePlan
+	hasError := false
+
+	for _, seedConfig := range seedConfigs {
+		plan := databasePlan{
+			Database: seedConfig.Name,
+			Username: seedConfig.Username,
+			Steps:    []planStep{},
+		}
+		steps, err := planDatabase(ctx, db.DB, planner, seedConfig, db.isRotating(seedConfig.Username))
+		if err != nil {
+			plan.Error = err.Error()
+			hasError = true
+		} else {
+			plan.Steps = steps
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, hasError
+}
+
+func planDatabase(ctx context.Context, db *sql.DB, planner dbPlanner, seedConfig SeedConfig, rotating bool) ([]planStep, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return planner(ctx, tx, seedConfig, rotating)
+}
+
+// writePlan renders the plans in the given format ("text" or "json").
+func writePlan(w io.Writer, plans []databasePlan, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plans)
+	case "text":
+		for _, plan := range plans {
+			fmt.Fprintf(w, "Database %s (user %s):", plan.Database, plan.Username)
+			if plan.Error != "" {
+				fmt.Fprintf(w, " error: %s\n", plan.Error)
+				continue
+			}
+			if len(plan.Steps) == 0 {
+				fmt.Fprintf(w, " no changes\n")
+				continue
+			}
+			fmt.Fprintf(w, "\n")
+			for _, step := range plan.Steps {
+				symbol := map[string]string{
+					actionCreateDatabase: "+",
+					actionCreateUser:     "+",
+					actionGrant:          "+",
+					actionChangePassword: "~",
+					actionRevoke:         "-",
+					actionDropUser:       "-",
+					actionDropDatabase:   "-",
+					actionRetain:         "=",
+					actionCharset:        "!",
+					actionSetLimits:      "~",
+				}[step.Action]
+				line := strings.Replace(step.Action, "-", " ", -1)
+				if step.Detail != "" {
+					line += " " + step.Detail
+				}
+				fmt.Fprintf(w, "  %s %s\n", symbol, line)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown plan format %q", format)
+}
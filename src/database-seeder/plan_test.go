@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWritePlanSymbols(t *testing.T) {
+	plans := []databasePlan{{
+		Database: "app",
+		Username: "app",
+		Steps: []planStep{
+			{Action: actionCreateDatabase},
+			{Action: actionChangePassword},
+			{Action: actionRevoke, Detail: "DROP"},
+			{Action: actionRetain},
+			{Action: actionCharset, Detail: "latin1 rather than utf8mb4"},
+			{Action: actionSetLimits, Detail: "MAX_USER_CONNECTIONS 10"},
+		},
+	}}
+	var buf bytes.Buffer
+	if err := writePlan(&buf, plans, "text"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Database app (user app):",
+		"  + create database",
+		"  ~ change password",
+		"  - revoke DROP",
+		"  = retain",
+		"  ! charset mismatch latin1 rather than utf8mb4",
+		"  ~ set limits MAX_USER_CONNECTIONS 10",
+	}
+	if got := buf.String(); got != strings.Join(want, "\n")+"\n" {
+		t.Errorf("writePlan =\n%swant\n%s", got, strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,1291 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresProfiles lists the table privileges for each profile; the owner
+// profile makes the role own the database instead.
+var postgresProfiles = map[string][]string{
+	profileOwner:     nil,
+	profileReadWrite: {"SELECT", "INSERT", "UPDATE", "DELETE"},
+	profileReadOnly:  {"SELECT"},
+}
+
+// Privileges that may be listed in SeedConfig.Privileges; database privileges
+// apply to the database itself, and the rest to all tables in it.
+var (
+	postgresDatabasePrivileges = []string{"CREATE", "TEMPORARY"}
+	postgresTablePrivileges    = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"}
+)
+
+// postgresSeededPrivileges resolves the privileges for a seed configuration
+// into database and table privileges.  If the role should own the database
+// instead, owner is set.
+func postgresSeededPrivileges(seedConfig SeedConfig) (owner bool, database, tables []string, err error) {
+	known := append(append([]string{}, postgresDatabasePrivileges...), postgresTablePrivileges...)
+	privileges, err := resolvePrivileges(seedConfig, known, postgresProfiles)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	if privileges == nil {
+		return true, nil, nil, nil
+	}
+
+	database = []string{"CONNECT"}
+	for _, privilege := range privileges {
+		if privilege == "CREATE" || privilege == "TEMPORARY" {
+			database = append(database, privilege)
+		} else {
+			tables = append(tables, privilege)
+		}
+	}
+	return false, database, tables, nil
+}
+
+// postgresSequencePrivileges returns the sequence privileges needed to go
+// with the given table privileges.
+func postgresSequencePrivileges(tables []string) []string {
+	var sequences []string
+	for _, privilege := range tables {
+		switch privilege {
+		case "INSERT", "UPDATE":
+			return []string{"USAGE", "SELECT", "UPDATE"}
+		case "SELECT":
+			sequences = []string{"SELECT"}
+		}
+	}
+	return sequences
+}
+
+func postgresCreator(ctx context.Context, db *connection, seedConfig SeedConfig) (changes seedChanges, err error) {
+
+	// exec runs a statement built from the given arguments, which must
+	// already have been quoted as appropriate.
+	exec := func(stmt string, args ...interface{}) (sql.Result, error) {
+		return db.ExecContext(ctx, fmt.Sprintf(stmt, args...))
+	}
+
+	err = validatePostgresSeedConfig(seedConfig)
+	if err != nil {
+		return changes, err
+	}
+
+	owner, databasePrivileges, tablePrivileges, err := postgresSeededPrivileges(seedConfig)
+	if err != nil {
+		return changes, err
+	}
+
+	var q postgresQuoter
+	database := q.Identifier(seedConfig.Name)
+	role := q.Identifier(seedConfig.Username)
+	password := q.Literal(seedConfig.Password)
+
+	state, err := inspectPostgres(ctx, db, seedConfig)
+	if err != nil {
+		return changes, err
+	}
+	changes, err = postgresChanges(ctx, db, state, seedConfig, owner, databasePrivileges)
+	if err != nil {
+		return changes, err
+	}
+	if mismatch := state.charsetMismatch(seedConfig); mismatch != "" {
+		changes.Warnings = append(changes.Warnings, mismatch+"; PostgreSQL cannot change it, so the database must be recreated")
+	}
+
+	// Create or update the login role.  While its password is being rotated,
+	// the new one belongs to the second role, so the password is left alone.
+	limits := limitClause(state.limits(seedConfig))
+	if state.RoleExists && db.isRotating(seedConfig.Username) {
+		_, err = exec("ALTER ROLE %s WITH LOGIN%s", role, limits)
+	} else if state.RoleExists {
+		_, err = exec("ALTER ROLE %s WITH LOGIN PASSWORD %s%s", role, password, limits)
+	} else {
+		_, err = exec("CREATE ROLE %s WITH LOGIN PASSWORD %s%s", role, password, limits)
+	}
+	if err != nil {
+		return changes, err
+	}
+
+	// Managed servers do not hand out superuser; ownership can only be given
+	// to a role we are a member of.
+	_, err = exec("GRANT %s TO CURRENT_USER", role)
+	if err != nil {
+		return changes, err
+	}
+
+	// Create the database; there is no CREATE DATABASE IF NOT EXISTS
+	if !owner {
+		if !state.DatabaseExists {
+			_, err = exec("CREATE DATABASE %s%s", database, postgresCreateOptions(seedConfig))
+			if err != nil {
+				return changes, err
+			}
+		}
+		err = postgresGrantPrivileges(ctx, db, seedConfig, databasePrivileges, tablePrivileges)
+		return changes, err
+	}
+
+	if !state.DatabaseExists {
+		_, err = exec("CREATE DATABASE %s OWNER %s%s", database, role, postgresCreateOptions(seedConfig))
+		if err != nil {
+			return changes, err
+		}
+		if seedConfig.Template != "" {
+			err = postgresReassignObjects(ctx, db, seedConfig)
+			if err != nil {
+				// Nothing but the copy is in the new database yet, and its
+				// objects would not be reassigned again
+				exec("DROP DATABASE %s", database)
+				return changes, fmt.Errorf("reassigning the objects copied from template database %s: %v", seedConfig.Template, err)
+			}
+		}
+	}
+
+	_, err = exec("ALTER DATABASE %s OWNER TO %s", database, role)
+	if err != nil {
+		return changes, err
+	}
+
+	_, err = exec("REVOKE CONNECT ON DATABASE %s FROM PUBLIC", database)
+	if err != nil {
+		return changes, err
+	}
+
+	return changes, nil
+}
+
+// postgresChanges works out what seeding will change, for reporting.  The
+// stored password can only be compared when connected as a superuser, and
+// table privileges are reapplied every time, so neither of those count.
+func postgresChanges(ctx context.Context, db *connection, state *postgresRoleState, seedConfig SeedConfig, owner bool, databasePrivileges []string) (seedChanges, error) {
+	changes := seedChanges{Database: statusUnchanged, User: statusUnchanged}
+	if !state.DatabaseExists {
+		changes.Database = statusCreated
+	}
+	if !state.RoleExists {
+		changes.User = statusCreated
+		return changes, nil
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return changes, err
+	}
+	defer tx.Rollback()
+	err = inspectPostgresPassword(ctx, tx, state, seedConfig.Username)
+	if err != nil {
+		return changes, err
+	}
+
+	changed := !state.CanLogin || !state.IsMember || len(changedLimits(state.limits(seedConfig))) > 0
+	if matches, known := state.passwordMatches(seedConfig.Username, seedConfig.Password); known && !matches && !db.isRotating(seedConfig.Username) {
+		changed = true
+	}
+	if state.DatabaseExists {
+		if owner {
+			changed = changed || state.Owner != seedConfig.Username
+		} else {
+			missing, extra := privilegeChanges(state.DatabasePrivileges, databasePrivileges)
+			changed = changed || state.Owner == seedConfig.Username || len(missing) > 0 || len(extra) > 0
+		}
+	}
+	if changed {
+		changes.User = statusChanged
+	}
+	return changes, nil
+}
+
+// postgresGrantPrivileges gives a role that does not own the database exactly
+// the requested privileges on it.  Each set of changes is made in a single
+// transaction, so the role never transiently loses access.  Tables the role
+// owns itself (from when it owned the database) keep all privileges.
+func postgresGrantPrivileges(ctx context.Context, db *connection, seedConfig SeedConfig, databasePrivileges, tablePrivileges []string) error {
+	var q postgresQuoter
+	database := q.Identifier(seedConfig.Name)
+	role := q.Identifier(seedConfig.Username)
+
+	statements := []string{
+		fmt.Sprintf("REVOKE CONNECT ON DATABASE %s FROM PUBLIC", database),
+		fmt.Sprintf("REVOKE ALL ON DATABASE %s FROM %s", database, role),
+		fmt.Sprintf("GRANT %s ON DATABASE %s TO %s", strings.Join(databasePrivileges, ", "), database, role),
+	}
+	var owner string
+	err := db.QueryRowContext(ctx, "SELECT pg_get_userbyid(datdba) FROM pg_database WHERE datname = $1", seedConfig.Name).Scan(&owner)
+	if err != nil {
+		return err
+	}
+	if owner == seedConfig.Username {
+		statements = append([]string{fmt.Sprintf("ALTER DATABASE %s OWNER TO CURRENT_USER", database)}, statements...)
+	}
+	err = execInTransaction(ctx, db.DB, statements)
+	if err != nil {
+		return err
+	}
+
+	target, err := db.openDatabase(seedConfig.Name)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	// Default privileges apply to objects created later by the database owner
+	err = target.QueryRowContext(ctx, "SELECT pg_get_userbyid(datdba) FROM pg_database WHERE datname = current_database()").Scan(&owner)
+	if err != nil {
+		return err
+	}
+	defaults := fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s IN SCHEMA public", q.Identifier(owner))
+
+	statements = []string{
+		fmt.Sprintf("REVOKE ALL ON ALL TABLES IN SCHEMA public FROM %s", role),
+		fmt.Sprintf("REVOKE ALL ON ALL SEQUENCES IN SCHEMA public FROM %s", role),
+		fmt.Sprintf("%s REVOKE ALL ON TABLES FROM %s", defaults, role),
+		fmt.Sprintf("%s REVOKE ALL ON SEQUENCES FROM %s", defaults, role),
+		fmt.Sprintf("GRANT USAGE ON SCHEMA public TO %s", role),
+	}
+	if len(tablePrivileges) > 0 {
+		tables := strings.Join(tablePrivileges, ", ")
+		statements = append(statements,
+			fmt.Sprintf("GRANT %s ON ALL TABLES IN SCHEMA public TO %s", tables, role),
+			fmt.Sprintf("%s GRANT %s ON TABLES TO %s", defaults, tables, role))
+	}
+	if sequencePrivileges := postgresSequencePrivileges(tablePrivileges); len(sequencePrivileges) > 0 {
+		sequences := strings.Join(sequencePrivileges, ", ")
+		statements = append(statements,
+			fmt.Sprintf("GRANT %s ON ALL SEQUENCES IN SCHEMA public TO %s", sequences, role),
+			fmt.Sprintf("%s GRANT %s ON SEQUENCES TO %s", defaults, sequences, role))
+	}
+	return execInTransaction(ctx, target, statements)
+}
+
+// postgresObject is an object in a database, as the kind and quoted name
+// that ALTER takes, with its owner.
+type postgresObject struct {
+	Kind  string
+	Name  string
+	Owner string
+}
+
+// String describes the object.
+func (o postgresObject) String() string {
+	return strings.ToLower(o.Kind) + " " + o.Name
+}
+
+// postgresUnownedObjectsQuery lists the objects in the connected database
+// that the role $1 does not own: schemas other than public, types, tables,
+// views and sequences not belonging to a table, and functions, leaving out
+// those of extensions.  Schemas come first, so that the role may create in
+// them by the time it owns what is in them.  prokind only exists from
+// PostgreSQL 11, so it is read through to_jsonb.
+const postgresUnownedObjectsQuery = `
+	SELECT o.kind, o.name, pg_get_userbyid(o.owner) FROM (
+		SELECT 1 AS rank, 'SCHEMA' AS kind, quote_ident(n.nspname) AS name, n.nspowner AS owner,
+			'pg_namespace'::regclass AS classid, n.oid
+		FROM pg_namespace n
+		WHERE n.nspname NOT IN ('public', 'information_schema') AND n.nspname !~ '^pg_'
+	UNION ALL
+		SELECT 2, CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END, format_type(t.oid, NULL), t.typowner,
+			'pg_type'::regclass, t.oid
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		LEFT JOIN pg_class c ON c.oid = t.typrelid
+		WHERE t.typtype IN ('c', 'd', 'e', 'r') AND (t.typtype <> 'c' OR c.relkind = 'c')
+		AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+	UNION ALL
+		SELECT 3, CASE c.relkind WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW' WHEN 'S' THEN 'SEQUENCE'
+				WHEN 'f' THEN 'FOREIGN TABLE' ELSE 'TABLE' END,
+			c.oid::regclass::text, c.relowner, 'pg_class'::regclass, c.oid
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f')
+		AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+		AND (c.relkind <> 'S' OR NOT EXISTS (
+			SELECT 1 FROM pg_depend d
+			WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid
+			AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')))
+	UNION ALL
+		SELECT 4, CASE COALESCE(to_jsonb(p)->>'prokind',
+				CASE WHEN EXISTS (SELECT 1 FROM pg_aggregate WHERE aggfnoid = p.oid) THEN 'a' END)
+				WHEN 'a' THEN 'AGGREGATE' WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END,
+			p.oid::regprocedure::text, p.proowner, 'pg_proc'::regclass, p.oid
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+	) o
+	WHERE pg_get_userbyid(o.owner) <> $1
+	AND NOT EXISTS (
+		SELECT 1 FROM pg_depend d
+		WHERE d.classid = o.classid AND d.objid = o.oid AND d.deptype = 'e')
+	ORDER BY o.rank, o.name`
+
+// postgresUnownedObjects returns the objects in the connected database that
+// the role does not own.
+func postgresUnownedObjects(ctx context.Context, db queryer, username string) ([]postgresObject, error) {
+	rows, err := db.QueryContext(ctx, postgresUnownedObjectsQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []postgresObject
+	for rows.Next() {
+		var object postgresObject
+		err = rows.Scan(&object.Kind, &object.Name, &object.Owner)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+// postgresReassignObjects gives the role the objects copied into its new
+// database from the template, which keep their owners from the template;
+// otherwise the role would own the database, but could not use what is in
+// it.  REASSIGN OWNED is not used, as it would also hand over every other
+// database of the template's owner.
+func postgresReassignObjects(ctx context.Context, db *connection, seedConfig SeedConfig) error {
+	target, err := db.openDatabase(seedConfig.Name)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	objects, err := postgresUnownedObjects(ctx, target, seedConfig.Username)
+	if err != nil {
+		return err
+	}
+	var q postgresQuoter
+	var statements []string
+	for _, object := range objects {
+		statements = append(statements, fmt.Sprintf("ALTER %s %s OWNER TO %s", object.Kind, object.Name, q.Identifier(seedConfig.Username)))
+	}
+	return execInTransaction(ctx, target, statements)
+}
+
+// execInTransaction runs the given statements in a single transaction.
+func execInTransaction(ctx context.Context, db *sql.DB, statements []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// postgresRoleState describes what currently exists on the server for a
+// seed configuration.
+type postgresRoleState struct {
+	RoleExists bool
+	CanLogin   bool
+	IsMember   bool
+	// ConnLimit is the CONNECTION LIMIT of the role; -1 for none
+	ConnLimit      int64
+	DatabaseExists bool
+	Owner          string
+	PublicConnect  bool
+	// Encoding and Collation are those of the database
+	Encoding  string
+	Collation string
+	// DatabasePrivileges held directly by the role on the database
+	DatabasePrivileges map[string]bool
+	// Password is the stored password hash; it is only available when
+	// connected as a superuser.
+	Password     sql.NullString
+	PasswordRead bool
+}
+
+// inspectPostgres looks up the role and database for a seed configuration.
+func inspectPostgres(ctx context.Context, db queryer, seedConfig SeedConfig) (*postgresRoleState, error) {
+	state := &postgresRoleState{ConnLimit: -1, DatabasePrivileges: make(map[string]bool)}
+
+	err := db.QueryRowContext(ctx,
+		"SELECT rolcanlogin, pg_has_role(CURRENT_USER, oid, 'MEMBER'), rolconnlimit FROM pg_roles WHERE rolname = $1",
+		seedConfig.Username).Scan(&state.CanLogin, &state.IsMember, &state.ConnLimit)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	state.RoleExists = err == nil
+
+	err = db.QueryRowContext(ctx, `
+		SELECT pg_get_userbyid(d.datdba), EXISTS (
+			SELECT 1 FROM aclexplode(COALESCE(d.datacl, acldefault('d', d.datdba))) a
+			WHERE a.grantee = 0 AND a.privilege_type = 'CONNECT'),
+			pg_encoding_to_char(d.encoding), d.datcollate
+		FROM pg_database d WHERE d.datname = $1`,
+		seedConfig.Name).Scan(&state.Owner, &state.PublicConnect, &state.Encoding, &state.Collation)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	state.DatabaseExists = err == nil
+
+	if state.RoleExists && state.DatabaseExists {
+		rows, err := db.QueryContext(ctx, `
+			SELECT a.privilege_type
+			FROM pg_database d, aclexplode(COALESCE(d.datacl, acldefault('d', d.datdba))) a
+			WHERE d.datname = $1 AND a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $2)`,
+			seedConfig.Name, seedConfig.Username)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var privilege string
+			err = rows.Scan(&privilege)
+			if err != nil {
+				return nil, err
+			}
+			state.DatabasePrivileges[privilege] = true
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+// limits returns the resource limits the seed configuration sets for the role.
+// A limit of 0 is written as -1, for none, as 0 would stop it logging in.
+func (state *postgresRoleState) limits(seedConfig SeedConfig) []resourceLimit {
+	wanted := seedConfig.Limits.MaxUserConnections
+	if wanted == nil {
+		return nil
+	}
+	limit := resourceLimit{Clause: "CONNECTION LIMIT", Wanted: *wanted, Current: state.ConnLimit}
+	if limit.Wanted == 0 {
+		limit.Wanted = -1
+	}
+	return []resourceLimit{limit}
+}
+
+// charsetMismatch describes how the encoding and collation of the database
+// differ from those asked for, if it exists and they do.
+func (state *postgresRoleState) charsetMismatch(seedConfig SeedConfig) string {
+	if !state.DatabaseExists {
+		return ""
+	}
+	return charsetMismatch(seedConfig, state.Encoding, state.Collation, postgresSameCharset)
+}
+
+// postgresSameCharset compares encoding or locale names, which may be spelled
+// in several ways, such as UTF8 and utf-8.
+func postgresSameCharset(a, b string) bool {
+	normalize := strings.NewReplacer("-", "", "_", "")
+	return strings.EqualFold(normalize.Replace(a), normalize.Replace(b))
+}
+
+// postgresCreateOptions returns the CREATE DATABASE options for the template,
+// encoding and collation of a seed configuration, if it names them.  Unless a
+// template is named, template0 is used with an encoding or collation, as the
+// other template databases may hold data in the default encoding.
+func postgresCreateOptions(seedConfig SeedConfig) string {
+	var q postgresQuoter
+	var options string
+	if seedConfig.Charset != "" {
+		options += " ENCODING " + q.Literal(seedConfig.Charset)
+	}
+	if seedConfig.Collation != "" {
+		options += " LC_COLLATE " + q.Literal(seedConfig.Collation) + " LC_CTYPE " + q.Literal(seedConfig.Collation)
+	}
+	if seedConfig.Template != "" {
+		options += " TEMPLATE " + q.Identifier(seedConfig.Template)
+	} else if options != "" {
+		options += " TEMPLATE template0"
+	}
+	return options
+}
+
+// inspectPostgresPassword reads the stored password hash of the role, if
+// possible.  pg_authid is only readable by superusers; a savepoint keeps a
+// permission failure from aborting the surrounding transaction.
+func inspectPostgresPassword(ctx context.Context, tx *sql.Tx, state *postgresRoleState, username string) error {
+	if !state.RoleExists {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "SAVEPOINT inspect_password")
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, "SELECT rolpassword FROM pg_authid WHERE rolname = $1", username).Scan(&state.Password)
+	if err == nil {
+		state.PasswordRead = true
+		_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT inspect_password")
+	} else {
+		_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT inspect_password")
+	}
+	return err
+}
+
+// passwordMatches reports whether the stored password matches.  If the hash
+// cannot be checked (because it is unreadable or salted), known is false.
+func (state *postgresRoleState) passwordMatches(username, password string) (matches, known bool) {
+	if !state.PasswordRead {
+		return false, false
+	}
+	if !state.Password.Valid {
+		return password == "", true
+	}
+	if strings.HasPrefix(state.Password.String, "md5") {
+		sum := md5.Sum([]byte(password + username))
+		return state.Password.String == "md5"+hex.EncodeToString(sum[:]), true
+	}
+	return false, false
+}
+
+func postgresPlanner(ctx context.Context, tx *sql.Tx, seedConfig SeedConfig, rotating bool) ([]planStep, error) {
+	err := validatePostgresSeedConfig(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, databasePrivileges, tablePrivileges, err := postgresSeededPrivileges(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := inspectPostgres(ctx, tx, seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = inspectPostgresPassword(ctx, tx, state, seedConfig.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []planStep{}
+	var q postgresQuoter
+	role := q.Identifier(seedConfig.Username)
+
+	if !state.RoleExists {
+		steps = append(steps, planStep{Action: actionCreateUser, Detail: role})
+	} else {
+		if !state.CanLogin {
+			steps = append(steps, planStep{Action: actionGrant, Detail: "LOGIN to " + role})
+		}
+		if rotating {
+			steps = append(steps, planStep{Action: actionRetain, Detail: "password of " + role + " while its rotation is in progress"})
+		} else if matches, known := state.passwordMatches(seedConfig.Username, seedConfig.Password); !known {
+			steps = append(steps, planStep{
+				Action: actionChangePassword,
+				Detail: fmt.Sprintf("%s (the stored password cannot be compared; it will be reset)", role),
+			})
+		} else if !matches {
+			steps = append(steps, planStep{Action: actionChangePassword, Detail: role})
+		}
+		if changed := changedLimits(state.limits(seedConfig)); len(changed) > 0 {
+			steps = append(steps, planStep{Action: actionSetLimits, Detail: joinLimits(changed) + " on " + role})
+		}
+	}
+
+	if !state.IsMember {
+		steps = append(steps, planStep{Action: actionGrant, Detail: "membership in " + role + " to CURRENT_USER"})
+	}
+
+	database := q.Identifier(seedConfig.Name)
+	if !state.DatabaseExists {
+		steps = append(steps, planStep{Action: actionCreateDatabase, Detail: templateDetail(seedConfig)})
+	}
+	if mismatch := state.charsetMismatch(seedConfig); mismatch != "" {
+		steps = append(steps, planStep{Action: actionCharset, Detail: mismatch})
+	}
+
+	if owner {
+		if state.DatabaseExists && state.Owner != seedConfig.Username {
+			steps = append(steps, planStep{Action: actionGrant, Detail: "ownership of " + database + " to " + role})
+		}
+	} else {
+		if state.DatabaseExists && state.Owner == seedConfig.Username {
+			steps = append(steps, planStep{Action: actionRevoke, Detail: "ownership of " + database + " from " + role})
+		}
+		missing, extra := privilegeChanges(state.DatabasePrivileges, databasePrivileges)
+		if len(missing) > 0 {
+			steps = append(steps, planStep{Action: actionGrant, Detail: strings.Join(missing, ", ") + " on " + database + " to " + role})
+		}
+		if len(extra) > 0 {
+			steps = append(steps, planStep{Action: actionRevoke, Detail: strings.Join(extra, ", ") + " on " + database + " from " + role})
+		}
+		// Table privileges are always reapplied in full
+		detail := "USAGE on schema public"
+		if len(tablePrivileges) > 0 {
+			detail = strings.Join(tablePrivileges, ", ") + " on all tables, and " + detail
+		}
+		steps = append(steps, planStep{Action: actionGrant, Detail: detail + " to " + role})
+	}
+
+	if !state.DatabaseExists || state.PublicConnect {
+		steps = append(steps, planStep{Action: actionRevoke, Detail: "CONNECT on " + database + " from PUBLIC"})
+	}
+
+	return steps, nil
+}
+
+// postgresVerifier logs in as the seeded role, and checks that it owns the
+// database if it should (and, if it was created from a template, everything
+// in it), or otherwise that it holds the database privileges
+// it should, and its table privileges on every table in the public schema.
+// Database privileges also granted to PUBLIC (such as TEMPORARY) cannot be
+// told apart, so only missing ones are reported.
+func postgresVerifier(ctx context.Context, db *connection, seedConfig SeedConfig) error {
+	owner, databasePrivileges, tablePrivileges, err := postgresSeededPrivileges(seedConfig)
+	if err != nil {
+		return err
+	}
+
+	userDB, err := db.openAs(seedConfig)
+	if err != nil {
+		return err
+	}
+	defer userDB.Close()
+
+	var isOwner bool
+	err = userDB.QueryRowContext(ctx,
+		"SELECT pg_get_userbyid(datdba) = current_user FROM pg_database WHERE datname = current_database()").Scan(&isOwner)
+	if err != nil {
+		return fmt.Errorf("could not log in: %v", err)
+	}
+	if owner != isOwner {
+		if owner {
+			return errors.New("does not own the database")
+		}
+		return errors.New("owns the database")
+	}
+	if owner && seedConfig.Template != "" {
+		objects, err := postgresUnownedObjects(ctx, userDB, seedConfig.Username)
+		if err != nil {
+			return err
+		}
+		if len(objects) > 0 {
+			return fmt.Errorf("does not own %s, owned by %s", objects[0], objects[0].Owner)
+		}
+	}
+	if owner {
+		return nil
+	}
+
+	var missing []string
+	for _, privilege := range databasePrivileges {
+		var held bool
+		err = userDB.QueryRowContext(ctx, "SELECT has_database_privilege(current_database(), $1)", privilege).Scan(&held)
+		if err != nil {
+			return err
+		}
+		if !held {
+			missing = append(missing, privilege)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing privileges %s on the database", strings.Join(missing, ", "))
+	}
+
+	var usage bool
+	err = userDB.QueryRowContext(ctx, "SELECT has_schema_privilege('public', 'USAGE')").Scan(&usage)
+	if err != nil {
+		return err
+	}
+	if !usage {
+		return errors.New("missing USAGE on schema public")
+	}
+
+	for _, privilege := range tablePrivileges {
+		var table string
+		err = userDB.QueryRowContext(ctx, `
+			SELECT c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = 'public' AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+			AND NOT has_table_privilege(c.oid, $1)
+			ORDER BY c.relname LIMIT 1`, privilege).Scan(&table)
+		if err == nil {
+			return fmt.Errorf("missing %s on table %s", privilege, table)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return nil
+}
+
+// postgresChecker compares the role, database and privileges of a seed
+// configuration with what postgresCreator would produce, including role
+// attributes beyond LOGIN, table privileges in the public schema, and the
+// owners of the objects in a database created from a template.
+func postgresChecker(ctx context.Context, db *connection, seedConfig SeedConfig) ([]driftFinding, error) {
+	owner, databasePrivileges, tablePrivileges, err := postgresSeededPrivileges(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+	state, err := inspectPostgres(ctx, db, seedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var q postgresQuoter
+	database := q.Identifier(seedConfig.Name)
+	role := q.Identifier(seedConfig.Username)
+	findings := []driftFinding{}
+	if !state.DatabaseExists {
+		findings = append(findings, driftFinding{Kind: driftMissingDatabase, Detail: seedConfig.Name})
+	}
+	if !state.RoleExists {
+		findings = append(findings, driftFinding{Kind: driftMissingUser, Detail: role})
+		return findings, nil
+	}
+
+	var attributes []string
+	err = db.QueryRowContext(ctx, `
+		SELECT array_remove(ARRAY[
+			CASE WHEN NOT rolcanlogin THEN 'NOLOGIN' END,
+			CASE WHEN rolsuper THEN 'SUPERUSER' END,
+			CASE WHEN rolcreatedb THEN 'CREATEDB' END,
+			CASE WHEN rolcreaterole THEN 'CREATEROLE' END,
+			CASE WHEN rolreplication THEN 'REPLICATION' END,
+			CASE WHEN rolbypassrls THEN 'BYPASSRLS' END], NULL)
+		FROM pg_roles WHERE rolname = $1`, seedConfig.Username).Scan(pq.Array(&attributes))
+	if err != nil {
+		return nil, err
+	}
+	for _, attribute := range attributes {
+		findings = append(findings, driftFinding{Kind: driftRoleAttribute, Detail: attribute + " on " + role})
+	}
+	findings = append(findings, limitDrift(state.limits(seedConfig), role)...)
+
+	if !state.DatabaseExists {
+		return findings, nil
+	}
+	if mismatch := state.charsetMismatch(seedConfig); mismatch != "" {
+		findings = append(findings, driftFinding{Kind: driftCharset, Detail: mismatch})
+	}
+	if state.PublicConnect {
+		findings = append(findings, driftFinding{Kind: driftExtraPrivilege, Detail: "CONNECT on " + database + " to PUBLIC"})
+	}
+	if owner != (state.Owner == seedConfig.Username) {
+		findings = append(findings, driftFinding{Kind: driftOwner, Detail: database + " is owned by " + q.Identifier(state.Owner)})
+	}
+	if owner {
+		if seedConfig.Template == "" {
+			return findings, nil
+		}
+		objectFindings, err := postgresCheckObjects(ctx, db, seedConfig)
+		if err != nil {
+			return nil, err
+		}
+		return append(findings, objectFindings...), nil
+	}
+
+	missing, extra := privilegeChanges(state.DatabasePrivileges, databasePrivileges)
+	for _, privilege := range missing {
+		findings = append(findings, driftFinding{Kind: driftMissingPrivilege, Detail: privilege + " on " + database})
+	}
+	for _, privilege := range extra {
+		findings = append(findings, driftFinding{Kind: driftExtraPrivilege, Detail: privilege + " on " + database})
+	}
+
+	tableFindings, err := postgresCheckTables(ctx, db, seedConfig, tablePrivileges)
+	if err != nil {
+		return nil, err
+	}
+	return append(findings, tableFindings...), nil
+}
+
+// postgresCheckObjects reports the objects in a database created from a
+// template that its owner does not own, such as those copied from the
+// template that could not be reassigned.
+func postgresCheckObjects(ctx context.Context, db *connection, seedConfig SeedConfig) ([]driftFinding, error) {
+	target, err := db.openDatabase(seedConfig.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	objects, err := postgresUnownedObjects(ctx, target, seedConfig.Username)
+	if err != nil {
+		return nil, err
+	}
+	var q postgresQuoter
+	var findings []driftFinding
+	for _, object := range objects {
+		findings = append(findings, driftFinding{Kind: driftOwner, Detail: fmt.Sprintf("%s in %s is owned by %s", object, q.Identifier(seedConfig.Name), q.Identifier(object.Owner))})
+	}
+	return findings, nil
+}
+
+// postgresCheckTables compares the privileges of the role on each table in the
+// public schema with those it should have.  Tables the role owns are skipped,
+// as seeding leaves them alone.
+func postgresCheckTables(ctx context.Context, db *connection, seedConfig SeedConfig, tablePrivileges []string) ([]driftFinding, error) {
+	target, err := db.openDatabase(seedConfig.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	rows, err := target.QueryContext(ctx, `
+		SELECT c.relname, COALESCE(array_agg(a.privilege_type::text) FILTER (WHERE a.privilege_type IS NOT NULL), '{}')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN LATERAL aclexplode(COALESCE(c.relacl, acldefault('r', c.relowner))) a
+			ON a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $1)
+		WHERE n.nspname = 'public' AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+		AND pg_get_userbyid(c.relowner) <> $1
+		GROUP BY c.relname ORDER BY c.relname`, seedConfig.Username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var q postgresQuoter
+	var findings []driftFinding
+	for rows.Next() {
+		var table string
+		var privileges []string
+		err = rows.Scan(&table, pq.Array(&privileges))
+		if err != nil {
+			return nil, err
+		}
+		held := make(map[string]bool)
+		for _, privilege := range privileges {
+			held[privilege] = true
+		}
+		missing, extra := privilegeChanges(held, tablePrivileges)
+		for _, privilege := range missing {
+			findings = append(findings, driftFinding{Kind: driftMissingPrivilege, Detail: privilege + " on table " + q.Identifier(table)})
+		}
+		for _, privilege := range extra {
+			findings = append(findings, driftFinding{Kind: driftExtraPrivilege, Detail: privilege + " on table " + q.Identifier(table)})
+		}
+	}
+	return findings, rows.Err()
+}
+
+var postgresBookkeeping = bookkeepingSQL{
+	setup: []string{
+		"CREATE SCHEMA IF NOT EXISTS " + bookkeepingSchema,
+		"CREATE TABLE IF NOT EXISTS " + bookkeepingSchema + ".managed_databases (" +
+			"name TEXT NOT NULL, " +
+			"username TEXT NOT NULL, " +
+			"PRIMARY KEY (name, username))",
+		"CREATE TABLE IF NOT EXISTS " + bookkeepingSchema + ".rotations (" +
+			"username TEXT NOT NULL PRIMARY KEY, " +
+			"started BIGINT NOT NULL)",
+	},
+	record: "INSERT INTO " + bookkeepingSchema + ".managed_databases (name, username) VALUES ($1, $2) " +
+		"ON CONFLICT DO NOTHING",
+	list:   "SELECT name, username FROM " + bookkeepingSchema + ".managed_databases ORDER BY name, username",
+	forget: "DELETE FROM " + bookkeepingSchema + ".managed_databases WHERE name = $1 AND username = $2",
+
+	startRotation: "INSERT INTO " + bookkeepingSchema + ".rotations (username, started) VALUES ($1, $2) " +
+		"ON CONFLICT DO NOTHING",
+	listRotations:  "SELECT username, started FROM " + bookkeepingSchema + ".rotations",
+	forgetRotation: "DELETE FROM " + bookkeepingSchema + ".rotations WHERE username = $1",
+}
+
+var postgresMigrations = migrationSQL{
+	setup: "CREATE TABLE IF NOT EXISTS " + migrationsTable + " (" +
+		"version BIGINT NOT NULL PRIMARY KEY, " +
+		"name TEXT NOT NULL, " +
+		"checksum TEXT NOT NULL, " +
+		"applied BIGINT NOT NULL)",
+	list:          "SELECT version, checksum FROM " + migrationsTable,
+	record:        "INSERT INTO " + migrationsTable + " (version, name, checksum, applied) VALUES ($1, $2, $3, $4)",
+	transactional: true,
+	dsn:           func(dsn string) (string, error) { return dsn, nil },
+}
+
+// postgresLock is a session-level advisory lock, keyed by a hash of its name.
+// pg_advisory_lock waits for it up to lock_timeout.
+var postgresLock = advisoryLock{
+	acquire: func(ctx context.Context, conn *sql.Conn, timeout time.Duration) (bool, error) {
+		var acquired bool
+		if timeout <= 0 {
+			err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", lockName).Scan(&acquired)
+			return acquired, err
+		}
+		_, err := conn.ExecContext(ctx, fmt.Sprintf("SET lock_timeout = %d", timeout/time.Millisecond))
+		if err != nil {
+			return false, err
+		}
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", lockName)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "55P03" { // lock_not_available
+			return false, nil
+		}
+		return err == nil, err
+	},
+	release: "SELECT pg_advisory_unlock(hashtext('" + lockName + "'))",
+}
+
+// postgresLoader loads data with COPY FROM STDIN, in a single transaction.
+// The driver sends rows as values, as the data file reader parses them.
+var postgresLoader = dataLoader{
+	empty: func(ctx context.Context, db *sql.DB, table string) (bool, error) {
+		var one int
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", postgresQuoter{}.Identifier(table))).Scan(&one)
+		if err == sql.ErrNoRows {
+			return true, nil
+		}
+		return false, err
+	},
+	load: func(ctx context.Context, db *sql.DB, load dataLoad) (int64, error) {
+		var q postgresQuoter
+		stmt := "COPY " + q.Identifier(load.table)
+		if len(load.columns) > 0 {
+			var columns []string
+			for _, column := range load.columns {
+				columns = append(columns, q.Identifier(column))
+			}
+			stmt += " (" + strings.Join(columns, ", ") + ")"
+		}
+		stmt += " FROM STDIN"
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+		copyIn, err := tx.PrepareContext(ctx, stmt)
+		if err != nil {
+			return 0, err
+		}
+		defer copyIn.Close()
+
+		var rows int64
+		for {
+			row, err := load.rows.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			values := make([]interface{}, len(row))
+			for i, field := range row {
+				if field.Valid {
+					values[i] = field.String
+				}
+			}
+			_, err = copyIn.ExecContext(ctx, values...)
+			if err != nil {
+				return 0, err
+			}
+			rows++
+		}
+
+		_, err = copyIn.ExecContext(ctx)
+		if err != nil {
+			return 0, err
+		}
+		err = copyIn.Close()
+		if err != nil {
+			return 0, err
+		}
+		return rows, tx.Commit()
+	},
+}
+
+// postgresRotatingSuffix names the second login role used while rotating a
+// role's password.
+const postgresRotatingSuffix = "_rotating"
+
+// postgresRotator rotates passwords using a second login role, as a role can
+// only have one password.  While the rotation is in progress, the new password
+// is valid for <user>_rotating, which acts as <user>; when it finishes, the
+// new password is given to <user> itself, and the second role expires after
+// options.retireAfter.  It is reused by later rotations.
+var postgresRotator = passwordRotator{
+	begin: func(ctx context.Context, db *sql.DB, seedConfig SeedConfig) error {
+		var q postgresQuoter
+		username, password := seedConfig.Username, seedConfig.Password
+		rotating := username + postgresRotatingSuffix
+		err := checkName("Username", rotating, 63, "bytes", func(s string) int { return len(s) })
+		if err != nil {
+			return err
+		}
+
+		var exists bool
+		err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", username).Scan(&exists)
+		if err == nil && !exists {
+			err = fmt.Errorf("role %s does not exist; seed it first", username)
+		}
+		if err != nil {
+			return err
+		}
+		err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", rotating).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		create := "CREATE ROLE %s WITH LOGIN PASSWORD %s%s"
+		if exists {
+			create = "ALTER ROLE %s WITH LOGIN PASSWORD %s%s VALID UNTIL 'infinity'"
+		}
+		// The second role has a connection limit of its own, like the real
+		// one's; anything it creates is owned by the real one
+		limits := limitClause((&postgresRoleState{}).limits(seedConfig))
+		return execInTransaction(ctx, db, []string{
+			fmt.Sprintf(create, q.Identifier(rotating), q.Literal(password), limits),
+			fmt.Sprintf("GRANT %s TO %s", q.Identifier(username), q.Identifier(rotating)),
+			fmt.Sprintf("ALTER ROLE %s SET role = %s", q.Identifier(rotating), q.Literal(username)),
+		})
+	},
+	finish: func(ctx context.Context, db *sql.DB, seedConfig SeedConfig, options rotateOptions) error {
+		var q postgresQuoter
+		username, password := seedConfig.Username, seedConfig.Password
+		rotating := username + postgresRotatingSuffix
+		expires := time.Now().Add(options.retireAfter).UTC().Format(time.RFC3339)
+
+		statements := []string{fmt.Sprintf("ALTER ROLE %s WITH LOGIN PASSWORD %s", q.Identifier(username), q.Literal(password))}
+		var exists bool
+		err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", rotating).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			statements = append(statements, fmt.Sprintf("ALTER ROLE %s VALID UNTIL %s", q.Identifier(rotating), q.Literal(expires)))
+		}
+		return execInTransaction(ctx, db, statements)
+	},
+	login: func(username string) string { return username + postgresRotatingSuffix },
+}
+
+// postgresPruner removes a database that is no longer listed.  Roles that may
+// still own objects in a retained database cannot be dropped from here, so
+// they are only prevented from logging in.
+func postgresPruner(ctx context.Context, db queryer, m managedDatabase, options pruneOptions) ([]pruneStatement, error) {
+	state, err := inspectPostgres(ctx, db, SeedConfig{Name: m.Name, Username: m.Username})
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []pruneStatement
+	var q postgresQuoter
+	database := q.Identifier(m.Name)
+	role := q.Identifier(m.Username)
+
+	if options.dropDatabase && state.DatabaseExists {
+		statements = append(statements, pruneStatement{
+			step: planStep{Action: actionDropDatabase, Detail: m.Name},
+			stmt: fmt.Sprintf("DROP DATABASE %s", database),
+		})
+	}
+
+	if m.Username == "" || !state.RoleExists {
+		return statements, nil
+	}
+
+	if !options.dropDatabase && state.DatabaseExists {
+		statements = append(statements, pruneStatement{
+			step: planStep{Action: actionRevoke, Detail: "ALL on " + database + " from " + role},
+			stmt: fmt.Sprintf("REVOKE ALL ON DATABASE %s FROM %s", database, role),
+		})
+		if state.Owner == m.Username {
+			statements = append(statements, pruneStatement{
+				step: planStep{Action: actionRevoke, Detail: "ownership of " + database + " from " + role},
+				stmt: fmt.Sprintf("ALTER DATABASE %s OWNER TO CURRENT_USER", database),
+			})
+		}
+	}
+
+	if options.dropUser {
+		if options.dropDatabase {
+			statements = append(statements, pruneStatement{
+				step: planStep{Action: actionDropUser, Detail: role},
+				stmt: fmt.Sprintf("DROP ROLE %s", role),
+			})
+		} else {
+			statements = append(statements, pruneStatement{
+				step: planStep{Action: actionRevoke, Detail: "LOGIN from " + role},
+				stmt: fmt.Sprintf("ALTER ROLE %s NOLOGIN", role),
+			})
+		}
+	}
+
+	return statements, nil
+}
+
+var postgresSSLModePattern = regexp.MustCompile(`(?:^|\s)sslmode\s*=\s*'?([\w-]+)'?`)
+
+// postgresDSNFor returns the connection string with its database replaced.
+// Later keywords override earlier ones, so the new value is simply appended.
+func postgresDSNFor(dsn, database string) (string, error) {
+	dsn, err := postgresConnInfo(dsn)
+	if err != nil {
+		return "", err
+	}
+	return dsn + " dbname=" + postgresDSNValue(database), nil
+}
+
+// postgresConnInfo converts URL connection strings to key=value form, which
+// can then be extended by appending keywords.
+func postgresConnInfo(dsn string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return pq.ParseURL(dsn)
+	}
+	return dsn, nil
+}
+
+// postgresDSNValue quotes a value for a key=value connection string.
+func postgresDSNValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
+
+// postgresDSNAs returns the connection string with its database and
+// credentials replaced.
+func postgresDSNAs(dsn, database, username, password string) (string, error) {
+	dsn, err := postgresDSNFor(dsn, database)
+	if err != nil {
+		return "", err
+	}
+	return dsn + " user=" + postgresDSNValue(username) + " password=" + postgresDSNValue(password), nil
+}
+
+// postgresBuildDSN builds a key=value connection string from connection
+// options.  A socket is given as the directory holding it, as with libpq.
+func postgresBuildDSN(options connectionOptions, password string) (string, error) {
+	host := options.host
+	if options.socket != "" {
+		host = options.socket
+	}
+	database := options.database
+	if database == "" {
+		database = "postgres"
+	}
+
+	keywords := append([][2]string{
+		{"host", host},
+		{"port", options.port},
+		{"user", options.username},
+		{"password", password},
+		{"dbname", database},
+	}, options.params...)
+	var dsn []string
+	for _, keyword := range keywords {
+		if keyword[1] != "" {
+			dsn = append(dsn, keyword[0]+"="+postgresDSNValue(keyword[1]))
+		}
+	}
+	return strings.Join(dsn, " "), nil
+}
+
+// postgresSSLMode returns the sslmode of a key=value connection string; the
+// last one given is the one in effect.
+func postgresSSLMode(dsn string) string {
+	var sslmode string
+	for _, match := range postgresSSLModePattern.FindAllStringSubmatch(dsn, -1) {
+		sslmode = match[1]
+	}
+	return sslmode
+}
+
+// postgresWithTLS returns the connection string with the TLS options added as
+// libpq keywords.  Giving a CA makes the server certificate be verified, as
+// with sslmode=verify-full, unless the DSN asks for verify-ca instead.  The
+// driver has no equivalent of a server name or minimum TLS version.
+func postgresWithTLS(dsn string, options tlsOptions) (string, error) {
+	if options.serverName != "" || options.minVersion != "" {
+		return "", errors.New("-tls-server-name and -tls-min-version are not supported for postgres")
+	}
+	dsn, err := postgresConnInfo(dsn)
+	if err != nil {
+		return "", err
+	}
+
+	sslmode := postgresSSLMode(dsn)
+	switch sslmode {
+	case "disable":
+		return "", errors.New("the DSN disables TLS with sslmode=disable")
+	case "verify-ca", "verify-full":
+	default:
+		sslmode = "require"
+		if options.ca != "" {
+			sslmode = "verify-full"
+		}
+	}
+
+	dsn += " sslmode=" + sslmode
+	if options.ca != "" {
+		dsn += " sslrootcert=" + postgresDSNValue(options.ca)
+	}
+	if options.cert != "" {
+		dsn += " sslcert=" + postgresDSNValue(options.cert) + " sslkey=" + postgresDSNValue(options.key)
+	}
+	return dsn, nil
+}
+
+// openPostgres opens a connection to a PostgreSQL server.  The driver only
+// understands some of the libpq sslmode values; "allow" and "prefer" are
+// emulated by attempting SSL first and falling back to plain connections.
+func openPostgres(dsn string) (*sql.DB, error) {
+	dsn, err := postgresConnInfo(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	sslmode := postgresSSLMode(dsn)
+	if sslmode != "allow" && sslmode != "prefer" {
+		return sql.Open("postgres", dsn)
+	}
+
+	// Later keywords override earlier ones in a connection string.
+	ssl, err := pq.NewConnector(dsn + " sslmode=require")
+	if err != nil {
+		return nil, err
+	}
+	plain, err := pq.NewConnector(dsn + " sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(preferSSLConnector{ssl: ssl, plain: plain}), nil
+}
+
+// preferSSLConnector makes each new connection try SSL first, falling back
+// to a plain connection if the server does not support SSL.
+type preferSSLConnector struct {
+	ssl   driver.Connector
+	plain driver.Connector
+}
+
+func (c preferSSLConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.ssl.Connect(ctx)
+	if err == pq.ErrSSLNotSupported {
+		return c.plain.Connect(ctx)
+	}
+	return conn, err
+}
+
+func (c preferSSLConnector) Driver() driver.Driver {
+	return c.ssl.Driver()
+}
+
+// postgresPermanentError returns whether a connection error will not go away
+// by retrying, such as a failure to authenticate.
+func postgresPermanentError(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code.Class() {
+		case "28": // invalid_authorization_specification
+			return true
+		case "3D": // invalid_catalog_name
+			return true
+		}
+		return false
+	}
+	return err == pq.ErrSSLNotSupported
+}
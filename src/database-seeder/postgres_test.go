@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostgresSSLMode(t *testing.T) {
+	for _, mode := range []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"} {
+		for _, dsn := range []string{
+			"host=x sslmode=" + mode,
+			"host=x sslmode='" + mode + "' dbname=y",
+			"sslmode=disable host=x sslmode = " + mode,
+		} {
+			if got := postgresSSLMode(dsn); got != mode {
+				t.Errorf("postgresSSLMode(%q) = %q, want %q", dsn, got, mode)
+			}
+		}
+	}
+	if got := postgresSSLMode("host=x"); got != "" {
+		t.Errorf("postgresSSLMode without sslmode = %q", got)
+	}
+}
+
+func TestPostgresWithTLS(t *testing.T) {
+	cert := tlsOptions{cert: "/c.pem", key: "/k.pem"}
+	ca := tlsOptions{ca: "/ca.pem"}
+	for _, test := range []struct {
+		dsn     string
+		options tlsOptions
+		sslmode string
+	}{
+		{"host=x", cert, "require"},
+		{"host=x", ca, "verify-full"},
+		{"host=x sslmode=allow", cert, "require"},
+		{"host=x sslmode=prefer", ca, "verify-full"},
+		{"host=x sslmode=require", cert, "require"},
+		{"host=x sslmode=require", ca, "verify-full"},
+		{"host=x sslmode=verify-ca", cert, "verify-ca"},
+		{"host=x sslmode=verify-ca", ca, "verify-ca"},
+		{"host=x sslmode=verify-full", cert, "verify-full"},
+		{"host=x sslmode=verify-full", ca, "verify-full"},
+		{"postgres://u@x/db?sslmode=verify-ca", ca, "verify-ca"},
+	} {
+		dsn, err := postgresWithTLS(test.dsn, test.options)
+		if err != nil {
+			t.Errorf("postgresWithTLS(%q): %v", test.dsn, err)
+			continue
+		}
+		if got := postgresSSLMode(dsn); got != test.sslmode {
+			t.Errorf("postgresWithTLS(%q) = %q, sslmode %q rather than %q", test.dsn, dsn, got, test.sslmode)
+		}
+	}
+
+	_, err := postgresWithTLS("host=x sslmode=disable", ca)
+	if err == nil || !strings.Contains(err.Error(), "disable") {
+		t.Errorf("postgresWithTLS with sslmode=disable: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Privilege profiles that can be named in SeedConfig.Profile.  The owner
+// profile is the default, and matches what was always granted before
+// profiles were introduced.
+const (
+	profileOwner     = "owner"
+	profileReadWrite = "readwrite"
+	profileReadOnly  = "readonly"
+)
+
+// resolvePrivileges returns the privileges requested by a seed configuration:
+// either its explicit Privileges, which must all be known to the dialect, or
+// those of its Profile.
+func resolvePrivileges(seedConfig SeedConfig, known []string, profiles map[string][]string) ([]string, error) {
+	if len(seedConfig.Privileges) == 0 {
+		profile := seedConfig.Profile
+		if profile == "" {
+			profile = profileOwner
+		}
+		privileges, ok := profiles[profile]
+		if !ok {
+			return nil, &seedConfigError{Field: "Profile", Value: seedConfig.Profile, Reason: "is not a known privilege profile"}
+		}
+		return privileges, nil
+	}
+
+	if seedConfig.Profile != "" {
+		return nil, &seedConfigError{Field: "Profile", Value: seedConfig.Profile, Reason: "must not be set together with Privileges"}
+	}
+
+	isKnown := make(map[string]bool)
+	for _, privilege := range known {
+		isKnown[privilege] = true
+	}
+
+	var privileges []string
+	seen := make(map[string]bool)
+	for _, privilege := range seedConfig.Privileges {
+		normalized := strings.Join(strings.Fields(strings.ToUpper(privilege)), " ")
+		if !isKnown[normalized] {
+			return nil, &seedConfigError{Field: "Privileges", Value: privilege, Reason: "is not a known database privilege"}
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			privileges = append(privileges, normalized)
+		}
+	}
+	return privileges, nil
+}
+
+// privilegeChanges compares the privileges held with those wanted, returning
+// the ones that are missing and the ones that are extra.
+func privilegeChanges(held map[string]bool, wanted []string) (missing, extra []string) {
+	isWanted := make(map[string]bool)
+	for _, privilege := range wanted {
+		isWanted[privilege] = true
+		if !held[privilege] {
+			missing = append(missing, privilege)
+		}
+	}
+	for privilege := range held {
+		if !isWanted[privilege] {
+			extra = append(extra, privilege)
+		}
+	}
+	sort.Strings(extra)
+	return missing, extra
+}
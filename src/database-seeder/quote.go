@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// sqlQuoter quotes identifiers and string literals for one SQL dialect.  It
+// is only used for statements (such as CREATE DATABASE or GRANT) where the
+// server does not accept placeholders; everything else should use them.
+type sqlQuoter interface {
+	Identifier(string) string
+	Literal(string) string
+}
+
+// mysqlQuoter quotes for MySQL-compatible servers.  Whether backslashes are
+// escape characters in string literals depends on the NO_BACKSLASH_ESCAPES
+// SQL mode of the session.
+type mysqlQuoter struct {
+	noBackslashEscapes bool
+}
+
+// Identifier quotes a database or table name in backticks.
+func (q mysqlQuoter) Identifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// mysqlGrantWildcards escapes the characters that GRANT and REVOKE take as
+// wildcards in database names.
+var mysqlGrantWildcards = strings.NewReplacer("_", `\_`, "%", `\%`)
+
+// GrantDatabase quotes a database name for GRANT and REVOKE, as SHOW GRANTS
+// writes it.  There, _ and % are wildcards unless escaped, so that a grant on
+// cf_db would also cover cfXdb.
+func (q mysqlQuoter) GrantDatabase(name string) string {
+	return q.Identifier(mysqlGrantWildcards.Replace(name))
+}
+
+// Literal quotes a string literal, such as a password or user name.
+func (q mysqlQuoter) Literal(value string) string {
+	if q.noBackslashEscapes {
+		return "'" + strings.Replace(value, "'", "''", -1) + "'"
+	}
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\x1a':
+			b.WriteString(`\Z`)
+		case '\'', '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// Account quotes a user name and host pattern as a MySQL account name.
+func (q mysqlQuoter) Account(user, host string) string {
+	return q.Literal(user) + "@" + q.Literal(host)
+}
+
+// postgresQuoter quotes for PostgreSQL servers.
+type postgresQuoter struct{}
+
+// Identifier quotes a database or role name in double quotes.
+func (postgresQuoter) Identifier(name string) string {
+	return pq.QuoteIdentifier(name)
+}
+
+// Literal quotes a string literal, such as a password.
+func (postgresQuoter) Literal(value string) string {
+	return pq.QuoteLiteral(value)
+}
+
+// seedConfigError reports a SeedConfig field whose value cannot be used on
+// the server.
+type seedConfigError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *seedConfigError) Error() string {
+	if e.Field == "Password" {
+		// Never echo the password back
+		return fmt.Sprintf("invalid SeedConfig.%s: %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("invalid SeedConfig.%s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// checkName verifies that a value is usable as a name on the server; maxLength
+// is in characters (for MySQL) or bytes (for PostgreSQL), as counted by length.
+func checkName(field, value string, maxLength int, unit string, length func(string) int) error {
+	fail := func(reason string, args ...interface{}) error {
+		return &seedConfigError{Field: field, Value: value, Reason: fmt.Sprintf(reason, args...)}
+	}
+	if value == "" {
+		return fail("must not be empty")
+	}
+	if !utf8.ValidString(value) {
+		return fail("is not valid UTF-8")
+	}
+	if strings.IndexByte(value, 0) > -1 {
+		return fail("must not contain NUL characters")
+	}
+	if length(value) > maxLength {
+		return fail("is longer than %d %s", maxLength, unit)
+	}
+	return nil
+}
+
+// checkPassword verifies that a password can be represented as a literal.
+func checkPassword(password string) error {
+	if strings.IndexByte(password, 0) > -1 {
+		return &seedConfigError{Field: "Password", Reason: "must not contain NUL characters"}
+	}
+	return nil
+}
+
+// validateMySQLSeedConfig rejects seed configurations that the server would
+// not be able to represent.
+func validateMySQLSeedConfig(server *mysqlServer, seedConfig SeedConfig) error {
+	err := checkName("Name", seedConfig.Name, 64, "characters", utf8.RuneCountInString)
+	if err != nil {
+		return err
+	}
+	// Identifiers are limited to the basic multilingual plane, and trailing
+	// spaces are not permitted in database names.
+	for _, r := range seedConfig.Name {
+		if r > 0xFFFF {
+			return &seedConfigError{Field: "Name", Value: seedConfig.Name, Reason: "must not contain supplementary characters"}
+		}
+	}
+	if strings.HasSuffix(seedConfig.Name, " ") {
+		return &seedConfigError{Field: "Name", Value: seedConfig.Name, Reason: "must not end with a space"}
+	}
+	if strings.Contains(seedConfig.Name, `\`) {
+		return &seedConfigError{Field: "Name", Value: seedConfig.Name, Reason: "must not contain backslashes, which GRANT takes as escapes"}
+	}
+
+	err = checkName("Username", seedConfig.Username, server.maxUsernameLength(), "characters", utf8.RuneCountInString)
+	if err != nil {
+		return err
+	}
+
+	hosts, err := mysqlHosts(seedConfig)
+	if err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		err = checkName("Hosts", host, server.maxHostLength(), "characters", utf8.RuneCountInString)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateLimits(seedConfig.Limits)
+	if err != nil {
+		return err
+	}
+
+	return checkPassword(seedConfig.Password)
+}
+
+// validatePostgresSeedConfig rejects seed configurations that the server would
+// not be able to represent.  Names longer than NAMEDATALEN-1 bytes would be
+// silently truncated, so those are rejected too.
+func validatePostgresSeedConfig(seedConfig SeedConfig) error {
+	byteLength := func(s string) int { return len(s) }
+	err := checkName("Name", seedConfig.Name, 63, "bytes", byteLength)
+	if err != nil {
+		return err
+	}
+	err = checkName("Username", seedConfig.Username, 63, "bytes", byteLength)
+	if err != nil {
+		return err
+	}
+	if len(seedConfig.Hosts) > 0 {
+		return &seedConfigError{Field: "Hosts", Value: strings.Join(seedConfig.Hosts, ","), Reason: "is not supported on PostgreSQL; restrict hosts in pg_hba.conf"}
+	}
+	if seedConfig.Limits.MaxQueriesPerHour != nil || seedConfig.Limits.MaxUpdatesPerHour != nil {
+		return &seedConfigError{Field: "Limits", Value: seedConfig.Limits.String(), Reason: "only max_user_connections is supported on PostgreSQL"}
+	}
+	err = validateLimits(seedConfig.Limits)
+	if err != nil {
+		return err
+	}
+	return checkPassword(seedConfig.Password)
+}
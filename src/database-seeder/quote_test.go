@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMySQLQuoterIdentifier(t *testing.T) {
+	q := mysqlQuoter{}
+	for name, want := range map[string]string{
+		"db":       "`db`",
+		"my`db":    "`my``db`",
+		"``":       "``````",
+		"a'b\"c\\": "`a'b\"c\\`",
+	} {
+		if got := q.Identifier(name); got != want {
+			t.Errorf("Identifier(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestMySQLQuoterGrantDatabase(t *testing.T) {
+	q := mysqlQuoter{}
+	for name, want := range map[string]string{
+		"db":     "`db`",
+		"cf_db":  "`cf\\_db`",
+		"a%b_c":  "`a\\%b\\_c`",
+		"x`_`y":  "`x``\\_``y`",
+		"__init": "`\\_\\_init`",
+	} {
+		if got := q.GrantDatabase(name); got != want {
+			t.Errorf("GrantDatabase(%q) = %s, want %s", name, got, want)
+		}
+	}
+
+	// SHOW GRANTS writes the database as it was granted
+	match := mysqlGrantPattern.FindStringSubmatch("GRANT SELECT, INSERT ON `cf\\_db`.* TO `app`@`%`")
+	if match == nil || match[2] != q.GrantDatabase("cf_db") {
+		t.Errorf("grant pattern matched %q", match)
+	}
+}
+
+func TestMySQLQuoterLiteral(t *testing.T) {
+	for _, test := range []struct {
+		value              string
+		noBackslashEscapes bool
+		want               string
+	}{
+		{"pw", false, "'pw'"},
+		{"it's", false, `'it\'s'`},
+		{`back\slash`, false, `'back\\slash'`},
+		{`"quoted"`, false, `'\"quoted\"'`},
+		{"nul\x00byte", false, `'nul\0byte'`},
+		{"line\nfeed\rreturn", false, `'line\nfeed\rreturn'`},
+		{"ctrl\x1aZ", false, `'ctrl\ZZ'`},
+		{`\'; DROP DATABASE x; --`, false, `'\\\'; DROP DATABASE x; --'`},
+		{"it's", true, "'it''s'"},
+		{`back\slash`, true, `'back\slash'`},
+		{`\''`, true, `'\'''''`},
+	} {
+		q := mysqlQuoter{noBackslashEscapes: test.noBackslashEscapes}
+		if got := q.Literal(test.value); got != test.want {
+			t.Errorf("Literal(%q), NO_BACKSLASH_ESCAPES %t = %s, want %s", test.value, test.noBackslashEscapes, got, test.want)
+		}
+	}
+}
+
+func TestMySQLQuoterAccount(t *testing.T) {
+	if got, want := (mysqlQuoter{}).Account("o'brien", "10.0.0.0/255.0.0.0"), `'o\'brien'@'10.0.0.0/255.0.0.0'`; got != want {
+		t.Errorf("Account = %s, want %s", got, want)
+	}
+}
+
+func TestPostgresQuoter(t *testing.T) {
+	var q postgresQuoter
+	for name, want := range map[string]string{
+		"db":       `"db"`,
+		`my"db`:    `"my""db"`,
+		"Mixed_Up": `"Mixed_Up"`,
+	} {
+		if got := q.Identifier(name); got != want {
+			t.Errorf("Identifier(%q) = %s, want %s", name, got, want)
+		}
+	}
+	for value, want := range map[string]string{
+		"pw":         `'pw'`,
+		"it's":       `'it''s'`,
+		`back\slash`: ` E'back\\slash'`,
+	} {
+		if got := q.Literal(value); got != want {
+			t.Errorf("Literal(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestValidateSeedConfigNames(t *testing.T) {
+	server := &mysqlServer{Flavor: flavorMySQL, Major: 8, Minor: 0, Patch: 30}
+	for _, test := range []struct {
+		seedConfig SeedConfig
+		field      string
+	}{
+		{SeedConfig{Name: "db\x00", Username: "u", Password: "p"}, "Name"},
+		{SeedConfig{Name: `d\b`, Username: "u", Password: "p"}, "Name"},
+		{SeedConfig{Name: "db ", Username: "u", Password: "p"}, "Name"},
+		{SeedConfig{Name: strings.Repeat("d", 65), Username: "u", Password: "p"}, "Name"},
+		{SeedConfig{Name: "db", Username: "", Password: "p"}, "Username"},
+		{SeedConfig{Name: "db", Username: "u", Password: "p\x00"}, "Password"},
+		{SeedConfig{Name: "db", Username: "u", Password: "p", Hosts: []string{"fe80::/64"}}, "Hosts"},
+	} {
+		err := validateMySQLSeedConfig(server, test.seedConfig)
+		configErr, ok := err.(*seedConfigError)
+		if !ok || configErr.Field != test.field {
+			t.Errorf("validateMySQLSeedConfig(%+v) = %v, want an error for %s", test.seedConfig, err, test.field)
+		}
+	}
+	if err := validateMySQLSeedConfig(server, SeedConfig{Name: "cf_db`1", Username: "o'brien", Password: `p'\`}); err != nil {
+		t.Errorf("validateMySQLSeedConfig: %v", err)
+	}
+
+	err := validatePostgresSeedConfig(SeedConfig{Name: strings.Repeat("d", 64), Username: "u", Password: "p"})
+	if configErr, ok := err.(*seedConfigError); !ok || configErr.Field != "Name" {
+		t.Errorf("validatePostgresSeedConfig with a long name = %v", err)
+	}
+
+	// Passwords are never echoed back
+	err = checkPassword("secret\x00")
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("checkPassword = %v", err)
+	}
+}
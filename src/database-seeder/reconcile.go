@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// bookkeepingSchema is the database (on MySQL) or schema (on PostgreSQL)
+// holding the seeder's own bookkeeping.
+const bookkeepingSchema = "database_seeder"
+
+// managedDatabase is a database and one of its users that was previously
+// seeded, as recorded in the bookkeeping table.  Username is empty for a
+// database that is no longer listed, whose users have been pruned, but which
+// was itself retained.
+type managedDatabase struct {
+	Name     string
+	Username string
+}
+
+// bookkeepingSQL holds the dialect-specific statements for maintaining the
+// bookkeeping table.
+type bookkeepingSQL struct {
+	setup  []string
+	record string
+	list   string
+	forget string
+
+	// Password rotations in progress
+	startRotation  string
+	listRotations  string
+	forgetRotation string
+}
+
+// pruneOptions describe how much of a database that is no longer listed should
+// be removed.
+type pruneOptions struct {
+	// dropUser is set if the user is not used by any listed database
+	dropUser bool
+	// dropDatabase is set if the database itself should be dropped
+	dropDatabase bool
+	// databaseListed is set if only the user is no longer listed
+	databaseListed bool
+}
+
+// pruneStatement is a statement to run while pruning, along with how it is
+// described in a plan.
+type pruneStatement struct {
+	step planStep
+	stmt string
+}
+
+// dbPruner returns the statements needed to remove a database that is no
+// longer listed, without executing them.
+type dbPruner func(context.Context, queryer, managedDatabase, pruneOptions) ([]pruneStatement, error)
+
+// setupBookkeeping creates the bookkeeping table if needed.
+func setupBookkeeping(ctx context.Context, db *sql.DB, d dialect) error {
+	for _, stmt := range d.bookkeeping.setup {
+		_, err := db.ExecContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordManaged marks a database as managed by the seeder.
+func recordManaged(ctx context.Context, db *sql.DB, d dialect, managed managedDatabase) error {
+	_, err := db.ExecContext(ctx, d.bookkeeping.record, managed.Name, managed.Username)
+	return err
+}
+
+// bookkeepingTableExists returns whether a bookkeeping table has been set up,
+// so that it can be read without setting it up, as when planning.  The table
+// is named in the query, as the dialects write placeholders differently.
+func bookkeepingTableExists(ctx context.Context, db queryer, table string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = '`+bookkeepingSchema+`' AND table_name = '`+table+`'`).Scan(&count)
+	return count > 0, err
+}
+
+// listManaged returns the databases managed by the seeder.  If the bookkeeping
+// table does not exist yet, nothing is managed.
+func listManaged(ctx context.Context, db queryer, d dialect) ([]managedDatabase, error) {
+	exists, err := bookkeepingTableExists(ctx, db, "managed_databases")
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, d.bookkeeping.list)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var managed []managedDatabase
+	for rows.Next() {
+		var m managedDatabase
+		err = rows.Scan(&m.Name, &m.Username)
+		if err != nil {
+			return nil, err
+		}
+		managed = append(managed, m)
+	}
+	return managed, rows.Err()
+}
+
+// staleDatabases returns the managed databases and users that are no longer
+// listed in the (expanded) seed configurations, along with how they should be
+// pruned.  Databases that are still listed are never dropped.
+func staleDatabases(managed []managedDatabase, seedConfigs []SeedConfig, dropDatabases bool) ([]managedDatabase, []pruneOptions) {
+	listed := make(map[managedDatabase]bool)
+	listedDatabases := make(map[string]bool)
+	listedUsers := make(map[string]bool)
+	for _, seedConfig := range seedConfigs {
+		listed[managedDatabase{Name: seedConfig.Name, Username: seedConfig.Username}] = true
+		listedDatabases[seedConfig.Name] = true
+		listedUsers[seedConfig.Username] = true
+	}
+
+	var stale []managedDatabase
+	var options []pruneOptions
+	for _, m := range managed {
+		databaseListed := listedDatabases[m.Name]
+		if listed[m] || (m.Username == "" && (databaseListed || !dropDatabases)) {
+			continue
+		}
+		stale = append(stale, m)
+		options = append(options, pruneOptions{
+			dropUser:       m.Username != "" && !listedUsers[m.Username],
+			dropDatabase:   dropDatabases && !databaseListed,
+			databaseListed: databaseListed,
+		})
+	}
+	return stale, options
+}
+
+// planPrune describes what pruning would do for each stale database.
+func planPrune(ctx context.Context, db queryer, d dialect, seedConfigs []SeedConfig, prune, dropDatabases bool) ([]databasePlan, bool) {
+	managed, err := listManaged(ctx, db, d)
+	if err != nil {
+		return []databasePlan{{Error: fmt.Sprintf("could not list managed databases: %v", err)}}, true
+	}
+
+	var plans []databasePlan
+	hasError := false
+	stale, options := staleDatabases(managed, seedConfigs, dropDatabases)
+	for i, m := range stale {
+		plan := databasePlan{Database: m.Name, Username: m.Username, Steps: []planStep{}}
+		if !prune {
+			plan.Steps = append(plan.Steps, planStep{Action: actionRetain, Detail: "no longer listed; use -prune to remove"})
+		} else if statements, err := d.prune(ctx, db, m, options[i]); err != nil {
+			plan.Error = err.Error()
+			hasError = true
+		} else {
+			for _, statement := range statements {
+				plan.Steps = append(plan.Steps, statement.step)
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans, hasError
+}
+
+// pruneStale removes (or reports) managed databases that are no longer listed
+// in the seed configurations.  Nothing is removed unless prune is set, and the
+// databases themselves are only dropped if dropDatabases is also set.
+func pruneStale(ctx context.Context, db *sql.DB, d dialect, seedConfigs []SeedConfig, prune, dropDatabases bool) bool {
+	managed, err := listManaged(ctx, db, d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing managed databases: %v\n", err)
+		return true
+	}
+
+	hasError := false
+	stale, options := staleDatabases(managed, seedConfigs, dropDatabases)
+	for i, m := range stale {
+		if !prune {
+			fmt.Printf("Database %s (user %s) is no longer listed; not pruning without -prune\n", m.Name, m.Username)
+			continue
+		}
+		fmt.Printf("Pruning database %s (user %s)...\n", m.Name, m.Username)
+		err = pruneDatabase(ctx, db, d, m, options[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning database %s: %v\n", m.Name, err)
+			hasError = true
+		}
+	}
+	return hasError
+}
+
+func pruneDatabase(ctx context.Context, db *sql.DB, d dialect, m managedDatabase, options pruneOptions) error {
+	statements, err := d.prune(ctx, db, m, options)
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		_, err = db.ExecContext(ctx, statement.stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.ExecContext(ctx, d.bookkeeping.forget, m.Name, m.Username)
+	if err != nil || options.dropDatabase || options.databaseListed {
+		return err
+	}
+	// Keep track of the retained database, so -prune-databases can drop it
+	return recordManaged(ctx, db, d, managedDatabase{Name: m.Name})
+}
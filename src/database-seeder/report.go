@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Statuses of a database or user in a report
+const (
+	statusCreated   = "created"
+	statusChanged   = "changed"
+	statusUnchanged = "unchanged"
+)
+
+// seedChanges describes what seeding changed for one database and user.
+type seedChanges struct {
+	Database string
+	User     string
+	// Warnings lists problems that seeding left alone
+	Warnings []string
+	// Migrations lists the migrations applied
+	Migrations []string
+	// Data lists the data files loaded
+	Data []dataLoaded
+}
+
+// reportEntry is the result of seeding one database and user.
+type reportEntry struct {
+	Database       string       `json:"database"`
+	Username       string       `json:"username"`
+	DatabaseStatus string       `json:"database_status,omitempty"`
+	UserStatus     string       `json:"user_status,omitempty"`
+	Seconds        float64      `json:"duration_seconds"`
+	Error          string       `json:"error,omitempty"`
+	VerifyError    string       `json:"verify_error,omitempty"`
+	Warnings       []string     `json:"warnings,omitempty"`
+	Migrations     []string     `json:"migrations_applied,omitempty"`
+	Data           []dataLoaded `json:"data_loaded,omitempty"`
+}
+
+// seedReport is the machine-readable result of a seeding run.
+type seedReport struct {
+	Success   bool          `json:"success"`
+	Seconds   float64       `json:"duration_seconds"`
+	Error     string        `json:"error,omitempty"`
+	Databases []reportEntry `json:"databases"`
+}
+
+// newSeedReport builds a report from the seeding outcomes.
+func newSeedReport(outcomes []seedOutcome, duration time.Duration, err error) *seedReport {
+	report := &seedReport{
+		Success:   err == nil,
+		Seconds:   duration.Seconds(),
+		Databases: []reportEntry{},
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for _, outcome := range outcomes {
+		entry := reportEntry{
+			Database:       outcome.seedConfig.Name,
+			Username:       outcome.seedConfig.Username,
+			DatabaseStatus: outcome.changes.Database,
+			UserStatus:     outcome.changes.User,
+			Seconds:        outcome.duration.Seconds(),
+			Warnings:       outcome.changes.Warnings,
+			Migrations:     outcome.changes.Migrations,
+			Data:           outcome.changes.Data,
+		}
+		if outcome.err != nil {
+			entry.Error = outcome.err.Error()
+			report.Success = false
+		}
+		if outcome.verifyErr != nil {
+			entry.VerifyError = outcome.verifyErr.Error()
+			report.Success = false
+		}
+		report.Databases = append(report.Databases, entry)
+	}
+	return report
+}
+
+// writeJSON writes the report as JSON.
+func (r *seedReport) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// reportPaths lists where seeding reports should be written, if anywhere.
+type reportPaths struct {
+	json  string
+	junit string
+}
+
+// write writes the report to each requested path.  Failures are printed, and
+// make the run fail.
+func (p reportPaths) write(report *seedReport) bool {
+	ok := true
+	if p.json != "" {
+		if err := report.writeJSON(p.json); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report %s: %v\n", p.json, err)
+			ok = false
+		}
+	}
+	if p.junit != "" {
+		if err := report.writeJUnit(p.junit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report %s: %v\n", p.junit, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit writes the report as JUnit XML, with one test case per database
+// and user.  Errors not tied to a database are reported as a failed test case
+// of their own.
+func (r *seedReport) writeJUnit(path string) error {
+	suite := junitTestSuite{
+		Name: "database-seeder",
+		Time: fmt.Sprintf("%.3f", r.Seconds),
+	}
+	if r.Error != "" {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			ClassName: "database-seeder",
+			Name:      "seeding",
+			Time:      suite.Time,
+			Failure:   &junitMessage{Message: r.Error},
+		})
+		suite.Failures++
+	}
+	for _, entry := range r.Databases {
+		testCase := junitTestCase{
+			ClassName: "database-seeder." + entry.Database,
+			Name:      entry.Username,
+			Time:      fmt.Sprintf("%.3f", entry.Seconds),
+		}
+		switch {
+		case entry.Error == errSkipped.Error():
+			testCase.Skipped = &junitMessage{Message: entry.Error}
+			suite.Skipped++
+		case entry.Error != "":
+			testCase.Failure = &junitMessage{Message: entry.Error}
+			suite.Failures++
+		case entry.VerifyError != "":
+			testCase.Failure = &junitMessage{Message: "verification failed: " + entry.VerifyError}
+			suite.Failures++
+		default:
+			testCase.SystemOut = fmt.Sprintf("database %s, user %s", entry.DatabaseStatus, entry.UserStatus)
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Tests = len(suite.Cases)
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// rotatingWarning is reported when seeding a user whose password is in the
+// middle of being rotated.  Everything but the password is seeded, as
+// resetting it would cut the rotation short.
+const rotatingWarning = "password rotation in progress; the password was left alone until rotate -finish"
+
+// rotateOptions control the rotate subcommand.
+type rotateOptions struct {
+	// finish retires the old passwords of rotations already begun, rather than
+	// beginning any
+	finish bool
+	// gracePeriod, if set, is how long to wait after beginning a rotation
+	// before finishing it in the same run
+	gracePeriod time.Duration
+	// retireAfter is how long the second login role used during a rotation
+	// remains usable after it finishes, on servers that need one
+	retireAfter time.Duration
+	// users limits the rotation to the given users; all are rotated if empty
+	users map[string]bool
+}
+
+// passwordRotator makes the operations of a two-phase password rotation
+// available for a dialect.  begin makes the new password of the user of a seed
+// configuration valid alongside the current one; finish makes it the only one.  Both may be repeated, so that an
+// interrupted rotation can be resumed.
+type passwordRotator struct {
+	begin  func(ctx context.Context, db *sql.DB, seedConfig SeedConfig) error
+	finish func(ctx context.Context, db *sql.DB, seedConfig SeedConfig, options rotateOptions) error
+	// login returns the user that logs in with the new password while a
+	// rotation is in progress
+	login func(username string) string
+}
+
+// isRotating returns whether the password of the user is being rotated.
+func (c *connection) isRotating(username string) bool {
+	_, ok := c.rotating[username]
+	return ok
+}
+
+// listRotations returns the rotations in progress, by user, with when each
+// began.  If the bookkeeping table does not exist yet, none are.
+func listRotations(ctx context.Context, db queryer, d dialect) (map[string]time.Time, error) {
+	exists, err := bookkeepingTableExists(ctx, db, "rotations")
+	if err != nil || !exists {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, d.bookkeeping.listRotations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotations := make(map[string]time.Time)
+	for rows.Next() {
+		var username string
+		var started int64
+		err = rows.Scan(&username, &started)
+		if err != nil {
+			return nil, err
+		}
+		rotations[username] = time.Unix(started, 0)
+	}
+	return rotations, rows.Err()
+}
+
+// rotatePasswords rotates the password of each seeded user to the one in its
+// seed configuration, in two phases: the new password is first added, and the
+// old one only dropped once the grace period has passed, or when run again
+// with options.finish.  Progress is recorded, so a rotation interrupted part
+// way through is picked up by the next run.  The seeder lock is released
+// while waiting out the grace period, so that other seeders are not held up.
+// It returns whether any errors occurred.
+func rotatePasswords(ctx context.Context, db *connection, lock *seederLock, seedConfigs []SeedConfig, options rotateOptions) bool {
+	d := db.dialect
+	rotations, err := listRotations(ctx, db.DB, d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing password rotations: %v\n", err)
+		return true
+	}
+
+	// Each user is rotated once, however many databases it has access to
+	var usernames []string
+	users := make(map[string]SeedConfig)
+	for _, seedConfig := range seedConfigs {
+		if _, seen := users[seedConfig.Username]; seen {
+			continue
+		}
+		if len(options.users) > 0 && !options.users[seedConfig.Username] {
+			continue
+		}
+		usernames = append(usernames, seedConfig.Username)
+		users[seedConfig.Username] = seedConfig
+	}
+
+	hasError := false
+	if !options.finish {
+		for _, username := range usernames {
+			started, ok := rotations[username]
+			if ok {
+				fmt.Printf("Resuming password rotation of user %s, begun %s...\n", username, started.Format(time.RFC3339))
+			} else {
+				fmt.Printf("Beginning password rotation of user %s...\n", username)
+				started = time.Now()
+			}
+			err = d.rotate.begin(ctx, db.DB, users[username])
+			if err == nil && !ok {
+				_, err = db.ExecContext(ctx, d.bookkeeping.startRotation, username, started.Unix())
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error beginning password rotation of user %s: %v\n", username, err)
+				delete(rotations, username)
+				hasError = true
+				continue
+			}
+			rotations[username] = started
+		}
+		if options.gracePeriod == 0 {
+			fmt.Printf("Both passwords are now valid; run rotate -finish once all clients use the new one.\n")
+			return hasError
+		}
+	}
+
+	for _, username := range usernames {
+		started, ok := rotations[username]
+		if !ok {
+			continue
+		}
+		if !options.finish {
+			wait := time.Until(started.Add(options.gracePeriod))
+			if wait > 0 {
+				fmt.Printf("Waiting %s before finishing password rotation of user %s...\n", wait.Round(time.Second), username)
+				err = lock.pause(ctx, wait)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error finishing password rotation of user %s: %v\n", username, err)
+					return true
+				}
+			}
+		}
+		fmt.Printf("Finishing password rotation of user %s...\n", username)
+		err = d.rotate.finish(ctx, db.DB, users[username], options)
+		if err == nil {
+			_, err = db.ExecContext(ctx, d.bookkeeping.forgetRotation, username)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finishing password rotation of user %s: %v\n", username, err)
+			hasError = true
+		}
+	}
+	return hasError
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretProvider resolves password references of one scheme, such as
+// "file:/path", to the password itself.
+type secretProvider interface {
+	// Resolve returns the secret named by the reference, which excludes the
+	// scheme and its colon.
+	Resolve(ctx context.Context, reference string) (string, error)
+}
+
+// secretProviders returns the providers for each supported reference scheme.
+func secretProviders(kubernetes *kubernetesClient) map[string]secretProvider {
+	return map[string]secretProvider{
+		"file":       fileSecrets{},
+		"env":        envSecrets{},
+		"k8s-secret": &kubernetesSecrets{client: kubernetes},
+	}
+}
+
+// resolvePasswords replaces each password that is a reference (such as
+// "env:NAME") with the secret it names.  Passwords without a known scheme are
+// used as they are.  Each reference is only resolved once.
+func resolvePasswords(ctx context.Context, seedConfigs []SeedConfig, providers map[string]secretProvider) error {
+	resolved := make(map[string]string)
+	for i, seedConfig := range seedConfigs {
+		scheme, reference, ok := splitSecretReference(seedConfig.Password, providers)
+		if !ok {
+			continue
+		}
+		password, ok := resolved[seedConfig.Password]
+		if !ok {
+			var err error
+			password, err = providers[scheme].Resolve(ctx, reference)
+			if err != nil {
+				return fmt.Errorf("could not resolve password of user %s for database %s: %v", seedConfig.Username, seedConfig.Name, err)
+			}
+			resolved[seedConfig.Password] = password
+		}
+		seedConfigs[i].Password = password
+	}
+	return nil
+}
+
+// splitSecretReference splits a password into the scheme and reference, if it
+// starts with the scheme of a known provider.
+func splitSecretReference(password string, providers map[string]secretProvider) (string, string, bool) {
+	i := strings.IndexByte(password, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	scheme := password[:i]
+	if _, ok := providers[scheme]; !ok {
+		return "", "", false
+	}
+	return scheme, password[i+1:], true
+}
+
+// fileSecrets reads passwords from files, such as "file:/path/to/password";
+// a trailing newline is ignored.
+type fileSecrets struct{}
+
+func (fileSecrets) Resolve(ctx context.Context, path string) (string, error) {
+	data, err := readSecretFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// envSecrets reads passwords from environment variables, such as "env:NAME".
+type envSecrets struct{}
+
+func (envSecrets) Resolve(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// kubernetesSecrets reads passwords from keys of Kubernetes secrets, such as
+// "k8s-secret:namespace/name#key"; the namespace defaults to the pod's own.
+type kubernetesSecrets struct {
+	client *kubernetesClient
+	// secrets caches the data of each secret, by namespace and name
+	secrets map[string]map[string][]byte
+}
+
+func (k *kubernetesSecrets) Resolve(ctx context.Context, reference string) (string, error) {
+	i := strings.LastIndexByte(reference, '#')
+	if i < 0 {
+		return "", fmt.Errorf("secret reference %q has no #key", reference)
+	}
+	path, key := reference[:i], reference[i+1:]
+
+	namespace, name := "", path
+	if j := strings.IndexByte(path, '/'); j >= 0 {
+		namespace, name = path[:j], path[j+1:]
+	}
+	if namespace == "" {
+		var err error
+		namespace, err = k.client.namespace()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if k.secrets == nil {
+		k.secrets = make(map[string]map[string][]byte)
+	}
+	data, ok := k.secrets[namespace+"/"+name]
+	if !ok {
+		var err error
+		data, err = k.client.getSecret(ctx, namespace, name)
+		if err != nil {
+			return "", err
+		}
+		k.secrets[namespace+"/"+name] = data
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %s", namespace, name, key)
+	}
+	return string(value), nil
+}
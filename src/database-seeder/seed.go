@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// errSkipped is reported for users of a database whose seeding was abandoned
+// because seeding an earlier user of the same database failed.
+var errSkipped = errors.New("skipped after an earlier error seeding this database")
+
+// seedOutcome is the result of seeding one user of a database.
+type seedOutcome struct {
+	seedConfig SeedConfig
+	changes    seedChanges
+	duration   time.Duration
+	err        error
+	// verifyErr is set if the user was seeded, but could not be verified
+	verifyErr error
+}
+
+// groupByDatabase groups the (expanded) seed configurations by database,
+// keeping the order in which each database first appears.
+func groupByDatabase(seedConfigs []SeedConfig) [][]SeedConfig {
+	var groups [][]SeedConfig
+	index := make(map[string]int)
+	for _, seedConfig := range seedConfigs {
+		i, ok := index[seedConfig.Name]
+		if !ok {
+			i = len(groups)
+			index[seedConfig.Name] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], seedConfig)
+	}
+	return groups
+}
+
+// clusterByUser clusters the groups of seed configurations that share a user,
+// directly or through other groups, returning the indexes of the groups in
+// each cluster, in order.
+func clusterByUser(groups [][]SeedConfig) [][]int {
+	parent := make([]int, len(groups))
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	first := make(map[string]int)
+	for i, group := range groups {
+		parent[i] = i
+		for _, seedConfig := range group {
+			j, ok := first[seedConfig.Username]
+			if !ok {
+				first[seedConfig.Username] = i
+				continue
+			}
+			// The earlier group stays the root, so clusters keep their order
+			a, b := find(j), find(i)
+			if a > b {
+				a, b = b, a
+			}
+			parent[b] = a
+		}
+	}
+
+	var clusters [][]int
+	index := make(map[int]int)
+	for i := range groups {
+		root := find(i)
+		c, ok := index[root]
+		if !ok {
+			c = len(clusters)
+			index[root] = c
+			clusters = append(clusters, nil)
+		}
+		clusters[c] = append(clusters[c], i)
+	}
+	return clusters
+}
+
+// seedDatabases seeds up to parallelism databases at once.  Databases sharing
+// a user are seeded one after another, so that the user is never created or
+// changed by two of them at once.  The results are reported in the order the
+// databases were listed, regardless of the order in which they complete.  It
+// returns the outcomes in the same order, and whether any errors occurred.  If
+// usersInPlace is set, databases and users are taken to be seeded already, and
+// only migrations and data are applied.
+func seedDatabases(ctx context.Context, db *connection, seedConfigs []SeedConfig, parallelism int, usersInPlace bool) ([]seedOutcome, bool) {
+	groups := groupByDatabase(seedConfigs)
+	results := make([]chan []seedOutcome, len(groups))
+	for i := range groups {
+		results[i] = make(chan []seedOutcome, 1)
+	}
+	slots := make(chan struct{}, parallelism)
+
+	for _, cluster := range clusterByUser(groups) {
+		go func(cluster []int) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			for _, i := range cluster {
+				results[i] <- seedDatabase(ctx, db, groups[i], usersInPlace)
+			}
+		}(cluster)
+	}
+
+	var outcomes []seedOutcome
+	hasError := false
+	for _, result := range results {
+		for _, outcome := range <-result {
+			outcomes = append(outcomes, outcome)
+			fmt.Printf("Seeding database %s (user %s)...\n", outcome.seedConfig.Name, outcome.seedConfig.Username)
+			if outcome.err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating database %s: %v\n", outcome.seedConfig.Name, outcome.err)
+				hasError = true
+			}
+			for _, name := range outcome.changes.Migrations {
+				fmt.Printf("Applied migration %s to database %s\n", name, outcome.seedConfig.Name)
+			}
+			for _, data := range outcome.changes.Data {
+				if data.Skipped {
+					fmt.Printf("Not loading %s into table %s of database %s, which already has rows\n", data.File, data.Table, outcome.seedConfig.Name)
+				} else {
+					fmt.Printf("Loaded %d rows from %s into table %s of database %s\n", data.Rows, data.File, data.Table, outcome.seedConfig.Name)
+				}
+			}
+			for _, warning := range outcome.changes.Warnings {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+			}
+		}
+	}
+	return outcomes, hasError
+}
+
+// seedDatabase seeds each user of one database in turn, unless usersInPlace is
+// set, and then applies its migrations and loads its data as the first user.
+// Once anything fails, the context for the database is cancelled, so the
+// remaining work for it is abandoned.
+func seedDatabase(ctx context.Context, db *connection, group []SeedConfig, usersInPlace bool) []seedOutcome {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var outcomes []seedOutcome
+	for _, seedConfig := range group {
+		if ctx.Err() != nil {
+			outcomes = append(outcomes, seedOutcome{seedConfig: seedConfig, err: errSkipped})
+			continue
+		}
+		if usersInPlace {
+			outcomes = append(outcomes, seedOutcome{
+				seedConfig: seedConfig,
+				changes:    seedChanges{Database: statusUnchanged, User: statusUnchanged},
+			})
+			continue
+		}
+		start := time.Now()
+		changes, err := db.dialect.create(ctx, db, seedConfig)
+		if db.isRotating(seedConfig.Username) {
+			changes.Warnings = append(changes.Warnings, rotatingWarning)
+		}
+		if err == nil {
+			err = recordManaged(ctx, db.DB, db.dialect, managedDatabase{Name: seedConfig.Name, Username: seedConfig.Username})
+		}
+		if err != nil {
+			cancel()
+		}
+		outcomes = append(outcomes, seedOutcome{
+			seedConfig: seedConfig,
+			changes:    changes,
+			duration:   time.Since(start),
+			err:        err,
+		})
+	}
+
+	if ctx.Err() == nil && (group[0].Migrations != "" || len(group[0].Data) > 0) {
+		start := time.Now()
+		outcome := &outcomes[0]
+		if group[0].Migrations != "" {
+			outcome.changes.Migrations, outcome.err = applyMigrations(ctx, db, group[0])
+		}
+		if outcome.err == nil && len(group[0].Data) > 0 {
+			outcome.changes.Data, outcome.err = loadData(ctx, db, group[0])
+		}
+		outcome.duration += time.Since(start)
+	}
+	return outcomes
+}
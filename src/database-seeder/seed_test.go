@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClusterByUser(t *testing.T) {
+	groups := groupByDatabase([]SeedConfig{
+		{Name: "a", Username: "u1"},
+		{Name: "b", Username: "u2"},
+		{Name: "c", Username: "u3"},
+		{Name: "c", Username: "u1"},
+		{Name: "d", Username: "u4"},
+		{Name: "e", Username: "u2"},
+		{Name: "e", Username: "u3"},
+	})
+	want := [][]int{{0, 1, 2, 4}, {3}}
+	if got := clusterByUser(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("clusterByUser = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsOptions describe how to secure the connection to the server, from the
+// -tls-* flags.  They are applied on top of whatever the DSN asks for.
+type tlsOptions struct {
+	// ca is a PEM file of the certificate authorities to trust, instead of the
+	// system ones
+	ca string
+	// cert and key are PEM files of a client certificate and its key
+	cert string
+	key  string
+	// serverName is the name to verify the server certificate against, if not
+	// the host connected to
+	serverName string
+	// minVersion is the oldest TLS version to accept, such as "1.2"
+	minVersion string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// enabled returns whether any TLS options were given.
+func (o tlsOptions) enabled() bool {
+	return o != tlsOptions{}
+}
+
+// validate checks that the options are consistent.
+func (o tlsOptions) validate() error {
+	if (o.cert == "") != (o.key == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if _, ok := tlsVersions[o.minVersion]; o.minVersion != "" && !ok {
+		return fmt.Errorf("unknown TLS version %q for -tls-min-version; use 1.0, 1.1, 1.2 or 1.3", o.minVersion)
+	}
+	return nil
+}
+
+// config builds the TLS configuration described by the options.
+func (o tlsOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: o.serverName,
+		MinVersion: tlsVersions[o.minVersion],
+	}
+
+	if o.ca != "" {
+		pem, err := ioutil.ReadFile(o.ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.ca)
+		}
+	}
+
+	if o.cert != "" {
+		cert, err := tls.LoadX509KeyPair(o.cert, o.key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// dbVerifier logs in as the user of a seed configuration, and checks that it
+// can use its database as intended.
+type dbVerifier func(context.Context, *connection, SeedConfig) error
+
+// openAs opens a new connection to the database of a seed configuration, as
+// its user.
+func (c *connection) openAs(seedConfig SeedConfig) (*sql.DB, error) {
+	dsn, err := c.dsnAs(seedConfig)
+	if err != nil {
+		return nil, err
+	}
+	db, err := c.dialect.open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Some checks depend on session state, such as locks
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// dsnAs returns the DSN for the database of a seed configuration, as its user.
+// While the password of the user is being rotated, it logs in as whoever has
+// the new password.
+func (c *connection) dsnAs(seedConfig SeedConfig) (string, error) {
+	username := seedConfig.Username
+	if c.isRotating(username) {
+		username = c.dialect.rotate.login(username)
+	}
+	return c.dialect.dsnAs(c.dsn, seedConfig.Name, username, seedConfig.Password)
+}
+
+// verifyOutcomes verifies each user that was seeded successfully, recording
+// any failure in its outcome.  It returns whether any verification failed.
+func verifyOutcomes(ctx context.Context, db *connection, outcomes []seedOutcome) bool {
+	hasError := false
+	for i, outcome := range outcomes {
+		if outcome.err != nil {
+			continue
+		}
+		fmt.Printf("Verifying database %s (user %s)...\n", outcome.seedConfig.Name, outcome.seedConfig.Username)
+		err := db.dialect.verify(ctx, db, outcome.seedConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying user %s of database %s: %v\n", outcome.seedConfig.Username, outcome.seedConfig.Name, err)
+			outcomes[i].verifyErr = err
+			hasError = true
+		}
+	}
+	return hasError
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// exitPermanentFailure is the exit code used when the server cannot be
+// connected to, and retrying would not help (for example, bad credentials).
+const exitPermanentFailure = 3
+
+// retryConfig controls how long to wait for the server to become ready.
+type retryConfig struct {
+	// timeout is the total time to keep retrying; zero means only try once
+	timeout time.Duration
+	// initialDelay is the delay before the first retry; it doubles with each
+	// attempt, up to maxDelay.
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+// permanentError wraps a connection error that retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// waitForServer pings the server until it responds, backing off exponentially
+// (with full jitter) between attempts.  Errors the dialect considers permanent
+// are returned immediately as a *permanentError.
+func waitForServer(ctx context.Context, db *sql.DB, d dialect, config retryConfig) error {
+	deadline := time.Now().Add(config.timeout)
+	delay := config.initialDelay
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for {
+		pingCtx, cancel := ctx, context.CancelFunc(func() {})
+		if config.timeout > 0 {
+			pingCtx, cancel = context.WithDeadline(ctx, deadline)
+		}
+		err := db.PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if d.permanentError(err) {
+			return &permanentError{err: err}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		sleep := time.Duration(random.Int63n(int64(delay) + 1))
+		if sleep > remaining {
+			sleep = remaining
+		}
+		fmt.Fprintf(os.Stderr, "Database not ready (%v); retrying in %s\n", err, sleep.Round(time.Millisecond))
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		delay *= 2
+		if delay > config.maxDelay {
+			delay = config.maxDelay
+		}
+	}
+}